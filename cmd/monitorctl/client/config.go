@@ -18,6 +18,9 @@ type Config struct {
 		Left  TimeConf // Left side of the dashboard
 		Right TimeConf // Right side
 	}
+	// Frequency is the interval at which new alerts are fetched, and Timespan
+	// is the lookback window used to get past alerts on startup. The timespan
+	// over which availability is computed is set in monitord's config file.
 	Alerts TimeConf
 }
 
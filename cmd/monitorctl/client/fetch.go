@@ -10,6 +10,7 @@ It contains:
 package client
 
 import (
+	"fmt"
 	"log"
 	"net/rpc"
 	"time"
@@ -52,8 +53,17 @@ func (f *Fetcher) Init() {
 
 	// Launch alert check routine
 	go func() {
-		for range time.Tick(time.Duration(c.Alerts.Frequency) * time.Second) {
-			f.GetAlerts(c.Alerts.Timespan)
+		// On startup, get the alerts of the last Timespan seconds,
+		// then only get the alerts recorded since the previous request
+		tf := payload.NewTimeframe(c.Alerts.Timespan)
+		f.GetAlerts(tf)
+		for now := range time.Tick(time.Duration(c.Alerts.Frequency) * time.Second) {
+			tf = payload.Timeframe{
+				StartDate: tf.EndDate,
+				EndDate:   now,
+				Seconds:   int(now.Sub(tf.EndDate) / time.Second),
+			}
+			f.GetAlerts(tf)
 		}
 	}()
 }
@@ -80,7 +90,8 @@ func (f *Fetcher) GetStats(timespan int) {
 			s.URLs = append(s.URLs, url)
 		}
 
-		// Add the received response time to the "average response time" graph data
+		// Add the received response time to the "average response time" graph data.
+		// Only valid poll results are used, so that errors do not make the website look fast
 		history := s.Metrics[url][stats.Timeframe.Seconds].AvgRespHist
 		start := 0
 		itemsToKeep := 30 // Number of points on the "average response time" graph
@@ -88,7 +99,7 @@ func (f *Fetcher) GetStats(timespan int) {
 			// Remove older data if necessary
 			start = len(history) - itemsToKeep + 1
 		}
-		history = append(history[start:], metric.Average.Response)
+		history = append(history[start:], metric.Valid.Average.Response)
 
 		// Save the resulting Metric to the store
 		s.Metrics[url][stats.Timeframe.Seconds] = Metric{
@@ -101,10 +112,10 @@ func (f *Fetcher) GetStats(timespan int) {
 	f.UpdateUI <- true // tell dashboard to rerender
 }
 
-// GetAlerts gets the latest websites Alerts from the daemon via RPC.
-func (f *Fetcher) GetAlerts(timespan int) {
-	// Craft and send request
-	tf := payload.NewTimeframe(timespan)
+// GetAlerts gets the websites Alerts recorded by the daemon
+// during the specified timeframe via RPC.
+func (f *Fetcher) GetAlerts(tf payload.Timeframe) {
+	// Send request
 	var alerts payload.Alerts
 	if err := f.CallRPC("Handler.Alerts", &tf, &alerts); err != nil {
 		log.Fatal(err.Error() + "; is the daemon running?")
@@ -113,14 +124,34 @@ func (f *Fetcher) GetAlerts(timespan int) {
 	// Save the resulting alerts to the store
 	s := f.Store
 	s.Lock()
-	for url, alert := range alerts {
-		s.Alerts[url] = append(s.Alerts[url], alert)
+	for _, alert := range alerts {
+		s.Alerts[alert.URL] = append(s.Alerts[alert.URL], alert)
 	}
 	s.Unlock()
 
 	f.UpdateUI <- true // tell dashboard to rerender
 }
 
+// PrintHistory gets from the daemon via RPC the incidents of the last
+// timespan seconds, and prints them on the standard output.
+//
+// If url is not empty, only the incidents of this website are printed.
+func (f *Fetcher) PrintHistory(timespan int, url string) {
+	// Craft and send request
+	q := payload.HistoryQuery{Timeframe: payload.NewTimeframe(timespan), URL: url}
+	var incidents payload.Incidents
+	if err := f.CallRPC("Handler.AlertHistory", &q, &incidents); err != nil {
+		log.Fatal(err.Error() + "; is the daemon running?")
+	}
+
+	if len(incidents) == 0 {
+		fmt.Println("No incident in the last", timespan, "seconds.")
+	}
+	for _, i := range incidents {
+		fmt.Print(FormatIncident(i))
+	}
+}
+
 // CallRPC connects to the daemon, calls the named function, waits for
 // it to complete, then closes the connection.
 func (f *Fetcher) CallRPC(method string, args interface{}, reply interface{}) error {
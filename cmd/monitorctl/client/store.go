@@ -19,7 +19,8 @@ import (
 type Store struct {
 	sync.RWMutex
 	URLs       []string
-	CurrentIdx int // Index of the currently displayed website (website order is defined by Store.URLs)
+	CurrentIdx int       // Index of the currently displayed website (website order is defined by Store.URLs)
+	Timings    TimingSet // Poll results whose timings are displayed (valid ones by default)
 	Metrics    Metrics
 	Alerts     Alerts
 }
@@ -39,6 +40,40 @@ type Metric struct {
 	AvgRespHist []time.Duration
 }
 
+// TimingSet selects which poll results the displayed timings are computed from.
+type TimingSet int
+
+// Available timing sets, in the order in which they are cycled through on the dashboard.
+const (
+	ValidTimings TimingSet = iota
+	AllTimings
+	InvalidTimings
+)
+
+// Name returns a human-readable name of the timing set.
+func (t TimingSet) Name() string {
+	switch t {
+	case AllTimings:
+		return "all polls"
+	case InvalidTimings:
+		return "failed polls"
+	default:
+		return "successful polls"
+	}
+}
+
+// Select returns the timing statistics of the metric that belong to the timing set.
+func (t TimingSet) Select(m payload.Metric) payload.TimingStats {
+	switch t {
+	case AllTimings:
+		return m.All
+	case InvalidTimings:
+		return m.Invalid
+	default:
+		return m.Valid
+	}
+}
+
 // Alerts maps from a URL to the alerts of the corresponding website.
 type Alerts map[string][]payload.Alert
 
@@ -50,8 +50,15 @@ func (d *UIDashboard) Show() {
 		select {
 		case <-d.UpdateUI:
 			// Refresh the widgets with the latest data
+			heights := d.Page.Left.Breakdown.Height + d.Page.Right.Breakdown.Height
 			d.Page.Refresh(d.Store)
 
+			// Recalculate the layout if a redirect chain changed the height of the breakdowns
+			if d.Page.Left.Breakdown.Height+d.Page.Right.Breakdown.Height != heights {
+				ui.Body.Align()
+				ui.Clear()
+			}
+
 			// Rerender UI
 			ui.Render(ui.Body)
 		case <-close:
@@ -77,10 +84,12 @@ func (d *UIDashboard) BuildLayout() {
 			ui.NewCol(6, 0, &d.Page.Left.Availability, &d.Page.Left.Breakdown),
 			ui.NewCol(6, 0, &d.Page.Right.Availability, &d.Page.Right.Breakdown)),
 		ui.NewRow(
-			ui.NewCol(3, 0, &d.Page.Left.CodeCounts), // Response code counts
-			ui.NewCol(3, 0, &d.Page.Left.RespGraph),  // Response time evolution graph
-			ui.NewCol(3, 0, &d.Page.Right.CodeCounts),
-			ui.NewCol(3, 0, &d.Page.Right.RespGraph),
+			ui.NewCol(2, 0, &d.Page.Left.CodeCounts), // Response code counts
+			ui.NewCol(2, 0, &d.Page.Left.Histogram),  // Response time distribution
+			ui.NewCol(2, 0, &d.Page.Left.RespGraph),  // Response time evolution graph
+			ui.NewCol(2, 0, &d.Page.Right.CodeCounts),
+			ui.NewCol(2, 0, &d.Page.Right.Histogram),
+			ui.NewCol(2, 0, &d.Page.Right.RespGraph),
 		),
 		ui.NewRow( // Latest client (non-HTTP) errors
 			ui.NewCol(6, 0, &d.Page.Left.Errors),
@@ -108,6 +117,16 @@ func (d *UIDashboard) RegisterEventHandlers() {
 		ui.Render(ui.Body)
 	})
 
+	// Switch between the timings of successful, all and failed polls when "T" key is pressed
+	ui.Handle("/sys/kbd/t", func(ui.Event) {
+		s := d.Store
+		s.Lock()
+		defer s.Unlock()
+
+		s.Timings = (s.Timings + 1) % (InvalidTimings + 1)
+		d.UpdateUI <- true
+	})
+
 	// Move to the next page when right arrow is pressed
 	ui.Handle("/sys/kbd/<right>", func(ui.Event) {
 		s := d.Store
@@ -6,6 +6,9 @@ package client
 
 import (
 	"strconv"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
 
 	ui "github.com/gizak/termui"
 )
@@ -14,7 +17,7 @@ import (
 //
 // Contrary to UIDashboard, UIPage only contains elements that are actually visible to the user.
 type UIPage struct {
-	Title   ui.Par // Shows the URL
+	Title   ui.Par // Shows the URL and the expiry of its TLS certificate
 	Counter ui.Par // Shows the index of the currently displayed website (e.g. 3/8)
 	Left    UISide // Stats presented on the left-hand side of the dashboard
 	Right   UISide // Stats presented on the right-hand side of the dashboard
@@ -34,10 +37,9 @@ func NewUIPage(c *Config) UIPage {
 
 	Alerts := ui.NewPar("")
 	Alerts.Height = 15
-	Alerts.BorderLabel = "Alerts (aggregated over " + strconv.Itoa(c.Alerts.Timespan) + "s, "
-	Alerts.BorderLabel += "refreshed every " + strconv.Itoa(c.Alerts.Frequency) + "s)"
+	Alerts.BorderLabel = "Alerts (refreshed every " + strconv.Itoa(c.Alerts.Frequency) + "s)"
 
-	Footer := ui.NewPar("Use left/right arrows to navigate, or press Q to quit")
+	Footer := ui.NewPar("Use left/right arrows to navigate, T to switch between successful/all/failed polls timings, or press Q to quit")
 	Footer.Height = 3
 	Footer.Border = false
 
@@ -64,19 +66,34 @@ func (p *UIPage) Refresh(s *Store) {
 	url := s.URLs[s.CurrentIdx]
 
 	// Update top-level widgets
-	p.Title.Text = url
+	latest := s.Metrics[url][p.Left.Timespan].Latest
+	p.Title.Text = url + FormatCertificate(latest.Certificate)
+	for _, w := range latest.Warnings {
+		p.Title.Text += " - WARNING: " + w
+	}
 	p.Counter.Text = "Page " + strconv.Itoa(s.CurrentIdx+1) + "/" + strconv.Itoa(len(s.URLs))
 	p.Alerts.Text = FormatAlerts(&s.Alerts, url)
 
 	// Update stats on both sides
-	p.Left.Refresh(s.Metrics[url][p.Left.Timespan])
-	p.Right.Refresh(s.Metrics[url][p.Right.Timespan])
+	p.Left.Refresh(s.Metrics[url][p.Left.Timespan], s.Timings)
+	p.Right.Refresh(s.Metrics[url][p.Right.Timespan], s.Timings)
 }
 
 // FormatAlerts converts alerts to a human-readable string,
 // to be displayed on the dashboard.
 func FormatAlerts(a *Alerts, url string) (str string) {
 	for _, alert := range (*a)[url] {
+		if alert.Type == payload.CertificateAlert && alert.Certificate != nil {
+			str += "Certificate of " + url
+			if alert.BelowThreshold {
+				str += " expires in " + strconv.Itoa(alert.Certificate.DaysUntilExpiry) + " days. "
+			} else {
+				str += " was renewed. "
+			}
+			str += "expiry=" + alert.Certificate.ChainNotAfter.String()
+			str += ", time=" + alert.Date.String() + "\n"
+			continue
+		}
 		str += "Website " + url + " is "
 		if alert.BelowThreshold {
 			str += "down. "
@@ -84,7 +101,34 @@ func FormatAlerts(a *Alerts, url string) (str string) {
 			str += "up. "
 		}
 		str += "availability=" + strconv.FormatFloat(alert.Availability, 'f', 3, 64)
-		str += ", time=" + alert.Timeframe.EndDate.String() + "\n"
+		str += ", time=" + alert.Date.String() + "\n"
+	}
+	return
+}
+
+// FormatCertificate converts the certificate of a website to a human-readable
+// string, to be displayed next to its URL. It returns "" if there is no certificate.
+func FormatCertificate(c *payload.Certificate) (str string) {
+	if c == nil {
+		return
+	}
+	str = " - TLS certificate expires in " + strconv.Itoa(c.DaysUntilExpiry) + " days"
+	str += " (issuer: " + c.Issuer + ")"
+	if !c.HostnameMatch {
+		str += " - WARNING: hostname mismatch"
+	}
+	return
+}
+
+// FormatIncident converts an incident to a human-readable string.
+func FormatIncident(i payload.Incident) (str string) {
+	str = "Website " + i.URL + " was down from " + i.StartDate.String()
+	if i.Ongoing() {
+		str += " and is still down"
+	} else {
+		str += " to " + i.EndDate.String()
 	}
+	str += " (" + i.Duration.Round(time.Second).String()
+	str += ", availability=" + strconv.FormatFloat(i.Availability, 'f', 3, 64) + ")\n"
 	return
 }
@@ -8,6 +8,7 @@ package client
 import (
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/anatolebeuzon/monitor/internal/payload"
@@ -23,8 +24,9 @@ type UISide struct {
 	Availability ui.Gauge     // Availability gauge
 	Breakdown    ui.Table     // HTTP lifecycle steps durations
 	CodeCounts   ui.BarChart  // Bar chart of the HTTP response codes counts
+	Histogram    ui.BarChart  // Bar chart of the response time distribution
 	RespGraph    ui.LineChart // Graph of response time evolution
-	Errors       ui.Par       // Latest client (non-HTTP) errors
+	Errors       ui.Par       // Latest client (non-HTTP) errors and policy violations
 }
 
 // NewUISide initializes the widgets of the dashboard side with the
@@ -46,12 +48,16 @@ func NewUISide(t TimeConf, color ui.Attribute) UISide {
 	Breakdown.Rows = [][]string{
 		[]string{"", "DNS", "TCP", "TLS", "Srv Process", "TTFB", "Transfer", "Response"},
 		[]string{}, // average values ; will be populated during render
+		[]string{}, // p50 values 	  ; same
+		[]string{}, // p90 values 	  ; same
+		[]string{}, // p95 values 	  ; same
+		[]string{}, // p99 values 	  ; same
 		[]string{}, // max values 	  ; same
 	}
 	Breakdown.FgColor = ui.ColorWhite
 	Breakdown.BgColor = ui.ColorDefault
 	Breakdown.BorderFg = color
-	Breakdown.Height = 5
+	Breakdown.Height = 9
 	Breakdown.TextAlign = ui.AlignCenter
 	Breakdown.Separator = false
 
@@ -60,15 +66,21 @@ func NewUISide(t TimeConf, color ui.Attribute) UISide {
 	CodeCounts.Height = 10
 	CodeCounts.BorderFg = color
 
+	Histogram := ui.NewBarChart()
+	Histogram.BorderLabel = "Response time distribution"
+	Histogram.Height = 10
+	Histogram.BarWidth = 5
+	Histogram.BorderFg = color
+
 	RespGraph := ui.NewLineChart()
-	RespGraph.BorderLabel = "Average response time evolution"
+	RespGraph.BorderLabel = "Average response time evolution (successful polls)"
 	RespGraph.Height = 10
 	RespGraph.BorderFg = color
 	RespGraph.Mode = "dot"
 	RespGraph.DotStyle = '+'
 
 	Errors := ui.NewPar("")
-	Errors.BorderLabel = "Latest errors"
+	Errors.BorderLabel = "Latest errors and policy violations"
 	Errors.Height = 7
 	Errors.BorderFg = color
 
@@ -78,15 +90,21 @@ func NewUISide(t TimeConf, color ui.Attribute) UISide {
 		*Availability,
 		*Breakdown,
 		*CodeCounts,
+		*Histogram,
 		*RespGraph,
 		*Errors,
 	}
 }
 
 // Refresh updates the UISide using the latest available data.
-func (s *UISide) Refresh(m Metric) {
+// The request breakdown shows the timings of the poll results selected by t.
+func (s *UISide) Refresh(m Metric, t TimingSet) {
 	// Update availability gauge
 	s.Availability.Percent = int(m.Latest.Availability * 100)
+	s.Availability.BorderLabel = "Availability"
+	if m.Latest.Recovered != 0 {
+		s.Availability.BorderLabel += " (" + strconv.Itoa(m.Latest.Recovered) + " polls succeeded after a retry)"
+	}
 
 	// Update color of the availability gauge
 	avail := s.Availability.Percent
@@ -99,12 +117,28 @@ func (s *UISide) Refresh(m Metric) {
 	}
 
 	// Update request timing breakdown
-	s.Breakdown.Rows[1] = FormatForTable("Avg", m.Latest.Average)
-	s.Breakdown.Rows[2] = FormatForTable("Max", m.Latest.Max)
+	timings := t.Select(m.Latest)
+	s.Breakdown.BorderLabel = "Request breakdown (" + t.Name() + ", " + strconv.Itoa(timings.Count) + " results)"
+	s.Breakdown.Rows[1] = FormatForTable("Avg", timings.Average)
+	s.Breakdown.Rows[2] = FormatPercentileForTable("p50", timings.P50, timings.Count)
+	s.Breakdown.Rows[3] = FormatPercentileForTable("p90", timings.P90, timings.Count)
+	s.Breakdown.Rows[4] = FormatPercentileForTable("p95", timings.P95, timings.Count)
+	s.Breakdown.Rows[5] = FormatPercentileForTable("p99", timings.P99, timings.Count)
+	s.Breakdown.Rows[6] = FormatForTable("Max", timings.Max)
+
+	// Append the redirect chain of the latest poll, if any
+	s.Breakdown.Rows = s.Breakdown.Rows[:7]
+	for _, hop := range m.Latest.Hops {
+		s.Breakdown.Rows = append(s.Breakdown.Rows, FormatForTable(FormatHop(hop), hop.Timing))
+	}
+	s.Breakdown.Height = len(s.Breakdown.Rows) + 2
 
 	// Update code counts
 	s.CodeCounts.DataLabels, s.CodeCounts.Data = ExtractResponseCounts(m.Latest)
 
+	// Update response time distribution
+	s.Histogram.DataLabels, s.Histogram.Data = ExtractHistogram(m.Latest)
+
 	// Update response time graph
 	s.RespGraph.Data = FormatForGraph(m.AvgRespHist)
 
@@ -113,6 +147,9 @@ func (s *UISide) Refresh(m Metric) {
 	for err, c := range m.Latest.ErrorCounts {
 		s.Errors.Text += err + " (" + strconv.Itoa(c) + " times)\n"
 	}
+	for v, c := range m.Latest.PolicyViolations {
+		s.Errors.Text += "policy: " + v + " (" + strconv.Itoa(c) + " times)\n"
+	}
 }
 
 // ExtractResponseCounts reads a metric and returns the corresponding
@@ -145,6 +182,29 @@ func ExtractResponseCounts(m payload.Metric) (codeNames []string, codeCounts []i
 	return
 }
 
+// ExtractHistogram reads a metric and returns the corresponding
+// slices that can be used to display a ui.BarChart of the response time distribution.
+//
+// Each bucket is labeled with its upper bound, except for the last bucket
+// which is labeled with its lower bound. For example, given:
+//	m.Histogram = []payload.Bucket{{100 * time.Millisecond, 5}, {time.Second, 2}, {0, 1}}
+// ExtractHistogram will return:
+// 	labels = []string{"100ms", "1s", ">1s"}
+//	counts = []int{5, 2, 1}
+func ExtractHistogram(m payload.Metric) (labels []string, counts []int) {
+	for i, b := range m.Histogram {
+		if i == len(m.Histogram)-1 && i > 0 {
+			labels = append(labels, ">"+m.Histogram[i-1].Max.String())
+		} else if b.Max == 0 {
+			labels = append(labels, "all")
+		} else {
+			labels = append(labels, b.Max.String())
+		}
+		counts = append(counts, b.Count)
+	}
+	return
+}
+
 // Count returns the total number of errors in the input map.
 func Count(errors map[string]int) (c int) {
 	for _, i := range errors {
@@ -167,6 +227,26 @@ func FormatForTable(prefix string, t payload.Timing) (s []string) {
 	return
 }
 
+// FormatPercentileForTable formats a percentile like FormatForTable, or with "-"
+// for each duration if it was not computed, i.e. if it is empty despite the
+// count of poll results (the timeframe was partly covered by rollups).
+func FormatPercentileForTable(prefix string, t payload.Timing, count int) []string {
+	if count == 0 || t != (payload.Timing{}) {
+		return FormatForTable(prefix, t)
+	}
+	return []string{prefix, "-", "-", "-", "-", "-", "-", "-"}
+}
+
+// FormatHop formats a hop of a redirect chain for use as a row prefix in a ui.Table,
+// e.g. "301 example.com/old". Long URLs are truncated.
+func FormatHop(h payload.Hop) string {
+	s := strconv.Itoa(h.StatusCode) + " " + strings.TrimPrefix(strings.TrimPrefix(h.URL, "http://"), "https://")
+	if r := []rune(s); len(r) > 24 {
+		s = string(r[:23]) + "…"
+	}
+	return s
+}
+
 // FormatForGraph formats a slice of durations for use in a ui.LineChart (i.e. a graph).
 //
 // Durations are rounded to the nearest millisecond, and converted to float64 values.
@@ -3,11 +3,15 @@ monitorctl displays a console dashboard with website statistics and alerts.
 It is a client for the monitord daemon.
 
 Usage :
-	monitorctl [-config path]
+	monitorctl [-config path] [-history seconds [-url url]]
 where path is the relative path to the config file of the client.
 If the config flag is not provided, monitorctl will look for
 a file named config.json in the current directory.
 
+If the history flag is provided, monitorctl prints the incidents
+(periods during which a website was down) of the last given seconds,
+optionally restricted to one website, and exits without showing the dashboard.
+
 Note that monitorctl's config file is different from monitord's.
 
 Once the dashboard is shown, you can navigate between websites using left and
@@ -30,7 +34,7 @@ A sample JSON config file is described below:
 		},
 		"Alerts": {
 			"Frequency": 4,			// Frequency at which the daemon should be polled for alerts
-			"Timespan": 120			// Timespan over which average availability should be computed
+			"Timespan": 120			// On startup, alerts of the last Timespan seconds are shown (alerts are evaluated by monitord)
 		}
 	}
 */
@@ -45,6 +49,8 @@ import (
 func main() {
 	// Load config
 	path := flag.String("config", "", "Path to JSON config file")
+	history := flag.Int("history", 0, "Print the incidents of the last n seconds and exit")
+	url := flag.String("url", "", "Restrict the incidents printed with -history to one website")
 	flag.Parse()
 	config := client.ReadConfig(*path)
 
@@ -53,6 +59,10 @@ func main() {
 
 	// Create new scheduler to regularly poll the daemon
 	f := client.NewFetcher(config, store)
+	if *history != 0 {
+		f.PrintHistory(*history, *url)
+		return
+	}
 	f.Init() // start polling
 
 	// Create and display a new dashboard
@@ -1,14 +1,19 @@
 /*
 This file contains the logic regarding poll results aggregation, such as:
 - getting the poll results of the last n seconds
+- completing them with rollups when raw poll results have expired
 - computing average availability
-- computing average and maximum times (response times, TLS handshake times, etc.)
-- counting HTTP response codes and counting client errors
+- computing average, maximum and percentile times (response times, TLS handshake times, etc.),
+  separately for valid and invalid poll results
+- counting HTTP response codes, client errors and policy violations
+- computing the distribution of response times
 */
 
 package daemon
 
 import (
+	"math"
+	"sort"
 	"time"
 
 	"github.com/anatolebeuzon/monitor/internal/payload"
@@ -16,15 +21,71 @@ import (
 
 // Aggregate returns a payload.Metric containing the statistics for the website,
 // aggregated over the specified timeframe in seconds.
+//
+// Raw poll results are used whenever they are available. The part of the
+// timeframe that precedes the oldest raw poll result is covered by rollups,
+// starting with the finest resolution, so that long timeframes can be
+// aggregated even after raw poll results have expired. In this case,
+// percentiles are not computed.
 func (w *Website) Aggregate(tf payload.Timeframe) payload.Metric {
 	p := w.PollResults.Extract(tf)
-	return payload.Metric{
-		Availability:     Availability(p),
-		Average:          Average(p),
-		Max:              Max(p),
-		StatusCodeCounts: CountCodes(p),
-		ErrorCounts:      CountErrors(p),
+
+	var rollups []Rollup
+	cutoff := tf.EndDate // Date before which raw poll results (or finer rollups) are missing
+	if len(p) != 0 {
+		cutoff = p[0].Date
+	}
+	for _, s := range w.Rollups {
+		// Include the bucket in which the cutoff falls, rather than leaving a gap
+		boundary := cutoff.Truncate(s.Resolution)
+		if boundary.Before(cutoff) {
+			boundary = boundary.Add(s.Resolution)
+		}
+		rs := s.Extract(tf.StartDate, boundary)
+		if len(rs) == 0 {
+			continue
+		}
+
+		// Discard the poll results and finer rollups that are already summarized
+		end := rs[len(rs)-1].Date.Add(s.Resolution)
+		for len(p) != 0 && p[0].Date.Before(end) {
+			p = p[1:]
+		}
+		for len(rollups) != 0 && rollups[0].Date.Before(end) {
+			rollups = rollups[1:]
+		}
+		rollups = append(append([]Rollup{}, rs...), rollups...)
+		cutoff = rs[0].Date
 	}
+
+	r := NewRollup(tf.StartDate, p, w.Buckets)
+	for _, o := range rollups {
+		r.Merge(o)
+	}
+	m := r.Metric(w.Buckets)
+
+	// Rollups do not keep individual timings, so percentiles can only be
+	// computed if raw poll results cover the whole timeframe. Otherwise,
+	// they are left empty rather than covering a shorter window than
+	// the other statistics.
+	if len(rollups) == 0 {
+		valid, invalid := SplitValid(p)
+		SetPercentiles(&m.All, p)
+		SetPercentiles(&m.Valid, valid)
+		SetPercentiles(&m.Invalid, invalid)
+	}
+
+	m.Certificate = LatestCertificate(p, tf.EndDate)
+	m.Warnings = w.Warnings
+	m.Hops = AggregateHops(p)
+	return m
+}
+
+// SetPercentiles computes the p50, p90, p95 and p99 timings of the poll results,
+// and saves them in the provided stats.
+func SetPercentiles(stats *payload.TimingStats, p []PollResult) {
+	pct := Percentiles(p, 0.5, 0.9, 0.95, 0.99)
+	stats.P50, stats.P90, stats.P95, stats.P99 = pct[0], pct[1], pct[2], pct[3]
 }
 
 // Extract returns the poll results that are included in the provided timeframe.
@@ -62,69 +123,177 @@ func Availability(p []PollResult) float64 {
 	}
 
 	// Compute availability
-	c := 0
+	return float64(CountValid(p)) / float64(len(p))
+}
+
+// SplitValid splits the poll results between valid and invalid ones.
+func SplitValid(p []PollResult) (valid, invalid []PollResult) {
 	for _, r := range p {
 		if IsValid(r) {
+			valid = append(valid, r)
+		} else {
+			invalid = append(invalid, r)
+		}
+	}
+	return
+}
+
+// CountValid returns the number of valid poll results.
+func CountValid(p []PollResult) (c int) {
+	for _, r := range p {
+		if IsValid(r) {
+			c++
+		}
+	}
+	return
+}
+
+// CountRecovered returns the number of valid poll results that needed more than one attempt.
+func CountRecovered(p []PollResult) (c int) {
+	for _, r := range p {
+		if IsValid(r) && r.Attempts > 1 {
 			c++
 		}
 	}
-	return float64(c) / float64(len(p))
+	return
 }
 
 // IsValid returns whether the poll result is considered valid or not.
 //
-// To be considered valid, the associated request must satisfy two criteria:
-// the request did not end with an error, and
-// the HTTP response code is neither a Client error nor a Server error.
+// To be considered valid, the associated request must satisfy three criteria:
+// the request did not end with an error,
+// the HTTP response code is neither a Client error nor a Server error, and
+// the response did not violate the website's policy (see Policy.Check).
 func IsValid(p PollResult) bool {
-	return (p.Error == nil) && (p.StatusCode < 400)
+	return (p.Error == nil) && (p.StatusCode < 400) && (p.Violation == "")
 }
 
 // Average returns a payload.Timing, in which each duration (DNS, TCP, TLS...)
 // is the average of the respective durations in the poll results.
 func Average(p []PollResult) (avg payload.Timing) {
-
 	// Perform an attribute-wise sum of durations
 	for _, r := range p {
-		avg.DNS += r.Timing.DNS
-		avg.TCP += r.Timing.TCP
-		avg.TLS += r.Timing.TLS
-		avg.Server += r.Timing.Server
-		avg.TTFB += r.Timing.TTFB
-		avg.Transfer += r.Timing.Transfer
-		avg.Response += r.Timing.Response
+		avg = AddTiming(avg, r.Timing)
 	}
 
 	// Divide by the number of elements to get the average
-	if len(p) != 0 {
-		n := time.Duration(len(p))
-		avg.DNS /= n
-		avg.TCP /= n
-		avg.TLS /= n
-		avg.Server /= n
-		avg.TTFB /= n
-		avg.Transfer /= n
-		avg.Response /= n
-	}
-
-	return
+	return DivideTiming(avg, len(p))
 }
 
 // Max returns a payload.Timing, in which each duration (DNS, TCP, TLS...)
 // is the maximum of the respective durations of the poll results.
 func Max(p []PollResult) (max payload.Timing) {
 	for _, r := range p {
-		max.DNS = MaxDuration(r.Timing.DNS, max.DNS)
-		max.TCP = MaxDuration(r.Timing.TCP, max.TCP)
-		max.TLS = MaxDuration(r.Timing.TLS, max.TLS)
-		max.Server = MaxDuration(r.Timing.Server, max.Server)
-		max.TTFB = MaxDuration(r.Timing.TTFB, max.TTFB)
-		max.Transfer = MaxDuration(r.Timing.Transfer, max.Transfer)
-		max.Response = MaxDuration(r.Timing.Response, max.Response)
+		max = MaxTiming(r.Timing, max)
 	}
 	return
 }
 
+// Percentiles returns, for each quantile q (between 0 and 1), a payload.Timing
+// in which each duration (DNS, TCP, TLS...) is the q-quantile of the respective
+// durations of the poll results.
+//
+// Quantiles are computed using the nearest-rank method. If there is no poll
+// result, zero timings are returned.
+func Percentiles(p []PollResult, qs ...float64) []payload.Timing {
+	// Gather and sort the durations of each phase
+	var phases [7][]time.Duration
+	for _, r := range p {
+		t := r.Timing
+		for i, d := range []time.Duration{t.DNS, t.TCP, t.TLS, t.Server, t.TTFB, t.Transfer, t.Response} {
+			phases[i] = append(phases[i], d)
+		}
+	}
+	for _, durations := range phases {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	}
+
+	timings := make([]payload.Timing, len(qs))
+	if len(p) == 0 {
+		return timings
+	}
+	for i, q := range qs {
+		// Nearest rank: smallest index such that at least q of the values are lower or equal
+		rank := int(math.Ceil(q*float64(len(p)))) - 1
+		if rank < 0 {
+			rank = 0
+		} else if rank >= len(p) {
+			rank = len(p) - 1
+		}
+		timings[i] = payload.Timing{
+			DNS:      phases[0][rank],
+			TCP:      phases[1][rank],
+			TLS:      phases[2][rank],
+			Server:   phases[3][rank],
+			TTFB:     phases[4][rank],
+			Transfer: phases[5][rank],
+			Response: phases[6][rank],
+		}
+	}
+	return timings
+}
+
+// AddTiming returns a payload.Timing, in which each duration (DNS, TCP, TLS...)
+// is the sum of the respective durations of t1 and t2.
+func AddTiming(t1, t2 payload.Timing) payload.Timing {
+	return payload.Timing{
+		DNS:      t1.DNS + t2.DNS,
+		TCP:      t1.TCP + t2.TCP,
+		TLS:      t1.TLS + t2.TLS,
+		Server:   t1.Server + t2.Server,
+		TTFB:     t1.TTFB + t2.TTFB,
+		Transfer: t1.Transfer + t2.Transfer,
+		Response: t1.Response + t2.Response,
+	}
+}
+
+// SubtractTiming returns a payload.Timing, in which each duration (DNS, TCP, TLS...)
+// is the respective duration of t1 minus the one of t2.
+func SubtractTiming(t1, t2 payload.Timing) payload.Timing {
+	return payload.Timing{
+		DNS:      t1.DNS - t2.DNS,
+		TCP:      t1.TCP - t2.TCP,
+		TLS:      t1.TLS - t2.TLS,
+		Server:   t1.Server - t2.Server,
+		TTFB:     t1.TTFB - t2.TTFB,
+		Transfer: t1.Transfer - t2.Transfer,
+		Response: t1.Response - t2.Response,
+	}
+}
+
+// DivideTiming returns a payload.Timing, in which each duration (DNS, TCP, TLS...)
+// is the respective duration of t divided by n.
+// If n is 0, t is returned unchanged.
+func DivideTiming(t payload.Timing, n int) payload.Timing {
+	if n == 0 {
+		return t
+	}
+	d := time.Duration(n)
+	return payload.Timing{
+		DNS:      t.DNS / d,
+		TCP:      t.TCP / d,
+		TLS:      t.TLS / d,
+		Server:   t.Server / d,
+		TTFB:     t.TTFB / d,
+		Transfer: t.Transfer / d,
+		Response: t.Response / d,
+	}
+}
+
+// MaxTiming returns a payload.Timing, in which each duration (DNS, TCP, TLS...)
+// is the maximum of the respective durations of t1 and t2.
+func MaxTiming(t1, t2 payload.Timing) payload.Timing {
+	return payload.Timing{
+		DNS:      MaxDuration(t1.DNS, t2.DNS),
+		TCP:      MaxDuration(t1.TCP, t2.TCP),
+		TLS:      MaxDuration(t1.TLS, t2.TLS),
+		Server:   MaxDuration(t1.Server, t2.Server),
+		TTFB:     MaxDuration(t1.TTFB, t2.TTFB),
+		Transfer: MaxDuration(t1.Transfer, t2.Transfer),
+		Response: MaxDuration(t1.Response, t2.Response),
+	}
+}
+
 // MaxDuration returns the maximum duration of two durations.
 func MaxDuration(d1, d2 time.Duration) time.Duration {
 	if d1 > d2 {
@@ -133,6 +302,20 @@ func MaxDuration(d1, d2 time.Duration) time.Duration {
 	return d2
 }
 
+// Histogram counts the poll results in each bucket of the response time distribution.
+//
+// buckets contains the upper bounds (excluded) of the buckets, sorted in ascending order.
+// The return value has one more item than buckets: the last item counts the poll results
+// whose response time is greater than or equal to the last upper bound.
+func Histogram(p []PollResult, buckets []time.Duration) []int {
+	counts := make([]int, len(buckets)+1)
+	for _, r := range p {
+		i := sort.Search(len(buckets), func(i int) bool { return r.Timing.Response < buckets[i] })
+		counts[i]++
+	}
+	return counts
+}
+
 // CountCodes counts the HTTP response codes in the poll results.
 // The return value maps from each HTTP response code encountered to the number of such codes.
 func CountCodes(p []PollResult) map[int]int {
@@ -157,3 +340,14 @@ func CountErrors(p []PollResult) map[string]int {
 	}
 	return errorsCount
 }
+
+// CountViolations counts the policy violations in the poll results.
+func CountViolations(p []PollResult) map[string]int {
+	violations := make(map[string]int)
+	for _, r := range p {
+		if r.Violation != "" {
+			violations[r.Violation]++
+		}
+	}
+	return violations
+}
@@ -0,0 +1,128 @@
+/*
+This file contains tests for the aggregation logic.
+*/
+
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Test of percentile computation
+func TestPercentiles(t *testing.T) {
+	// Create table of test cases
+	testCases := []struct {
+		responseTimes []time.Duration
+		expected      [4]time.Duration // p50, p90, p95, p99
+	}{
+		{
+			// No poll result: zero timings expected
+			nil,
+			[4]time.Duration{0, 0, 0, 0},
+		},
+		{
+			// Single poll result: all percentiles are equal
+			[]time.Duration{42},
+			[4]time.Duration{42, 42, 42, 42},
+		},
+		{
+			// Unsorted poll results
+			[]time.Duration{5, 1, 4, 2, 3},
+			[4]time.Duration{3, 5, 5, 5},
+		},
+		{
+			// 1 to 100: the q-quantile is 100*q
+			durations(1, 100),
+			[4]time.Duration{50, 90, 95, 99},
+		},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			var p []PollResult
+			for _, d := range tc.responseTimes {
+				p = append(p, PollResult{Timing: payload.Timing{Response: d, DNS: 2 * d}})
+			}
+			computed := Percentiles(p, 0.5, 0.9, 0.95, 0.99)
+
+			// Check the result
+			for j := range computed {
+				if computed[j].Response != tc.expected[j] || computed[j].DNS != 2*tc.expected[j] {
+					t.Errorf("Percentile %v: expected %v, got %v", j, tc.expected[j], computed[j])
+				}
+			}
+		})
+	}
+}
+
+// Test of the response time distribution
+func TestHistogram(t *testing.T) {
+	buckets := []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 800 * time.Millisecond}
+	var p []PollResult
+	for _, ms := range []int{0, 99, 100, 250, 300, 799, 800, 5000} {
+		p = append(p, PollResult{Timing: payload.Timing{Response: time.Duration(ms) * time.Millisecond}})
+	}
+
+	computed := Histogram(p, buckets)
+	expected := []int{2, 2, 2, 2}
+	if fmt.Sprint(computed) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, computed)
+	}
+	if computed := Histogram(p, nil); len(computed) != 1 || computed[0] != len(p) {
+		t.Errorf("Expected a single bucket, got %v", computed)
+	}
+}
+
+// Test of the separate timings of valid and invalid poll results
+//
+// Fast errors should not lower the timings of valid poll results.
+func TestAggregateValidInvalid(t *testing.T) {
+	now := time.Now()
+	result := func(ms int, code int, err error) PollResult {
+		d := time.Duration(ms) * time.Millisecond
+		return PollResult{Date: now.Add(-time.Second), Timing: payload.Timing{Response: d}, StatusCode: code, Error: err}
+	}
+	w := buildWebsite(false,
+		result(200, 200, nil),
+		result(400, 200, nil),
+		result(10, 500, nil),
+		result(0, 0, errors.New("dial tcp: connection refused")),
+	)
+
+	m := w.Aggregate(payload.NewTimeframe(10))
+	testCases := []struct {
+		name    string
+		stats   payload.TimingStats
+		count   int
+		average time.Duration
+		max     time.Duration
+		median  time.Duration
+	}{
+		{"all", m.All, 4, 152500 * time.Microsecond, 400 * time.Millisecond, 10 * time.Millisecond},
+		{"valid", m.Valid, 2, 300 * time.Millisecond, 400 * time.Millisecond, 200 * time.Millisecond},
+		{"invalid", m.Invalid, 2, 5 * time.Millisecond, 10 * time.Millisecond, 0},
+	}
+	for _, tc := range testCases {
+		s := tc.stats
+		if s.Count != tc.count || s.Average.Response != tc.average || s.Max.Response != tc.max || s.P50.Response != tc.median {
+			t.Errorf("%v: expected count=%v avg=%v max=%v p50=%v, got count=%v avg=%v max=%v p50=%v", tc.name,
+				tc.count, tc.average, tc.max, tc.median, s.Count, s.Average.Response, s.Max.Response, s.P50.Response)
+		}
+	}
+}
+
+// durations is a helper function to build test cases.
+// It returns the durations between min and max (included), in nanoseconds,
+// sorted in decreasing order.
+func durations(min, max int) (d []time.Duration) {
+	for i := max; i >= min; i-- {
+		d = append(d, time.Duration(i))
+	}
+	return
+}
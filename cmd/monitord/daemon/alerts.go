@@ -0,0 +1,285 @@
+/*
+This file contains the alerting logic, namely:
+- how and when websites' availability is checked against their threshold
+- how alerts are recorded, so that any number of clients can read them
+- how alerts are persisted on disk, so that they survive daemon restarts
+- how alerts are grouped into incidents, to reconstruct past outages
+*/
+
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Default alerting settings, used if they are not set in the config file.
+const (
+	DefaultAlertInterval = 4   // Interval, in seconds, between two evaluations
+	DefaultAlertTimespan = 120 // Timespan, in seconds, over which availability is computed
+)
+
+// AlertEngine regularly evaluates the availability of all websites
+// and records an alert each time a website crosses its threshold,
+// or when its TLS certificate is about to expire.
+//
+// It runs on its own schedule, independently of RPC clients:
+// alerts are generated even if no client is connected, and reading
+// them has no side effect on the alerting state.
+type AlertEngine struct {
+	Websites Websites
+	Interval int           // Interval, in seconds, between two evaluations
+	Timespan int           // Timespan, in seconds, over which availability is computed
+	History  *AlertHistory // All the alerts generated by the engine
+
+	// Notifiers are informed of every alert, e.g. to page website maintainers
+	Notifiers []*NotificationQueue
+}
+
+// AlertHistory contains all the alerts generated by the AlertEngine.
+//
+// If a log file is provided in the config file, alerts are appended to it
+// as they are generated, and reloaded from it on daemon startup.
+type AlertHistory struct {
+	sync.RWMutex
+	items payload.Alerts
+	file  *os.File // File in which alerts are persisted, or nil if alerts are only kept in memory
+}
+
+// NewAlertEngine creates a new AlertEngine for the provided websites.
+//
+// The alert history is reloaded from the log file, if any, and the alerting
+// state of each website is restored from its latest alert.
+// The program exits if an error is encountered while reading the log file.
+func NewAlertEngine(w Websites, c *Config) *AlertEngine {
+	h, err := OpenAlertHistory(c.Alerts.Log)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Avoid sending a repetitive "website is down!" alert after a restart
+	for i := range w {
+		if a, ok := h.Last(w[i].URL, payload.AvailabilityAlert); ok {
+			w[i].DownAlertSent = a.BelowThreshold
+		}
+		if a, ok := h.Last(w[i].URL, payload.CertificateAlert); ok {
+			w[i].CertAlertSent = a.BelowThreshold
+		}
+	}
+
+	e := &AlertEngine{
+		Websites: w,
+		Interval: c.Alerts.Interval,
+		Timespan: c.Alerts.Timespan,
+		History:  h,
+	}
+	for _, n := range NewNotifiers(w, c) {
+		e.Notifiers = append(e.Notifiers, NewNotificationQueue(n))
+	}
+	if e.Interval == 0 {
+		e.Interval = DefaultAlertInterval
+	}
+	if e.Timespan == 0 {
+		e.Timespan = DefaultAlertTimespan
+	}
+	return e
+}
+
+// OpenAlertHistory returns an AlertHistory containing the alerts previously
+// saved in the log file at path, and opens the file to append new alerts to it.
+//
+// If path is empty, the returned AlertHistory only keeps alerts in memory.
+func OpenAlertHistory(path string) (*AlertHistory, error) {
+	h := &AlertHistory{}
+	if path == "" {
+		return h, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read alerts, one JSON document per line
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var a payload.Alert
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			// The line may have been partially written if the daemon crashed:
+			// skip it rather than refusing to start
+			fmt.Println("Skipping invalid alert in", path, ":", err)
+			continue
+		}
+		if a.Type == "" {
+			// Alerts saved before certificate alerts were introduced
+			a.Type = payload.AvailabilityAlert
+		}
+		h.items = append(h.items, a)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	h.file = f
+	return h, nil
+}
+
+// Start launches the evaluation of websites' availability in a separate goroutine.
+func (e *AlertEngine) Start() {
+	go func() {
+		for range time.Tick(time.Duration(e.Interval) * time.Second) {
+			e.Evaluate(payload.NewTimeframe(e.Timespan))
+		}
+	}()
+}
+
+// Evaluate checks the availability and the certificate of each website over
+// the specified timeframe, records the resulting alerts in the history,
+// sends them to the notifiers and returns them.
+func (e *AlertEngine) Evaluate(tf payload.Timeframe) (alerts payload.Alerts) {
+	for i := range e.Websites {
+		if a, ok := e.Websites[i].CheckAlert(tf); ok {
+			alerts = append(alerts, e.History.Add(a))
+		}
+		if a, ok := e.Websites[i].CheckCertificate(tf); ok {
+			alerts = append(alerts, e.History.Add(a))
+		}
+	}
+	if len(alerts) != 0 {
+		Notify(e.Notifiers, alerts)
+	}
+	return
+}
+
+// CheckAlert compares the availability of the website
+// (on average, over the specified timeframe) against its threshold.
+//
+// If the threshold was crossed since the last check, it returns
+// the corresponding alert and true. Otherwise, it returns false.
+func (w *Website) CheckAlert(tf payload.Timeframe) (payload.Alert, bool) {
+	// Get average availability
+	avail := Availability(w.PollResults.Extract(tf))
+
+	if (avail < w.Threshold) && !w.DownAlertSent {
+		// if the website is considered down but no alert for this event was sent yet
+		// create a "website is down" alert
+		w.DownAlertSent = true
+		return payload.Alert{Type: payload.AvailabilityAlert, URL: w.URL, Timeframe: tf, Availability: avail, Threshold: w.Threshold, BelowThreshold: true}, true
+	} else if (avail >= w.Threshold) && w.DownAlertSent {
+		// if the website is considered up but website was last reported down
+		// create a "website has recovered" alert
+		w.DownAlertSent = false
+		return payload.Alert{Type: payload.AvailabilityAlert, URL: w.URL, Timeframe: tf, Availability: avail, Threshold: w.Threshold, BelowThreshold: false}, true
+	}
+	return payload.Alert{}, false
+}
+
+// Add dates the alert, saves it at the end of the history and returns it.
+//
+// The date is set while holding the lock, so that the history is
+// always sorted by increasing date.
+// If the history has a log file, the alert is also appended to it.
+func (h *AlertHistory) Add(a payload.Alert) payload.Alert {
+	h.Lock()
+	defer h.Unlock()
+
+	a.Date = time.Now()
+	h.items = append(h.items, a)
+
+	if h.file != nil {
+		if err := h.persist(a); err != nil {
+			fmt.Println("Could not persist alert:", err)
+		}
+	}
+	return a
+}
+
+// persist appends the alert to the log file, and flushes it to disk
+// so that it is not lost if the daemon crashes.
+func (h *AlertHistory) persist(a payload.Alert) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if _, err = h.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return h.file.Sync()
+}
+
+// Last returns the latest alert of the given type for the website,
+// and false if there is none.
+func (h *AlertHistory) Last(url string, typ string) (payload.Alert, bool) {
+	h.RLock()
+	defer h.RUnlock()
+
+	for i := len(h.items) - 1; i >= 0; i-- {
+		if h.items[i].URL == url && h.items[i].Type == typ {
+			return h.items[i], true
+		}
+	}
+	return payload.Alert{}, false
+}
+
+// Extract returns the alerts that were recorded during the provided timeframe.
+func (h *AlertHistory) Extract(tf payload.Timeframe) payload.Alerts {
+	h.RLock()
+	defer h.RUnlock()
+
+	alerts := payload.Alerts{}
+	for _, a := range h.items {
+		if !a.Date.Before(tf.StartDate) && a.Date.Before(tf.EndDate) {
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts
+}
+
+// Incidents groups the alerts of the history into incidents, and returns
+// the incidents that match the query.
+//
+// An incident starts with a "website is down" alert and ends with the next
+// recovery alert of the same website. Certificate alerts are ignored. The duration of ongoing incidents
+// is computed up to now.
+func (h *AlertHistory) Incidents(q payload.HistoryQuery, now time.Time) payload.Incidents {
+	h.RLock()
+	defer h.RUnlock()
+
+	// Build incidents, keeping track of the ongoing incident of each website
+	var all payload.Incidents
+	ongoing := make(map[string]int) // Maps from a URL to the index of its ongoing incident in all
+	for _, a := range h.items {
+		if (q.URL != "" && a.URL != q.URL) || a.Type != payload.AvailabilityAlert {
+			continue
+		}
+		i, isDown := ongoing[a.URL]
+		if a.BelowThreshold && !isDown {
+			ongoing[a.URL] = len(all)
+			all = append(all, payload.Incident{URL: a.URL, StartDate: a.Date, Availability: a.Availability})
+		} else if !a.BelowThreshold && isDown {
+			all[i].EndDate = a.Date
+			all[i].Duration = a.Date.Sub(all[i].StartDate)
+			delete(ongoing, a.URL)
+		}
+	}
+
+	// Only keep the incidents that overlap the timeframe
+	incidents := payload.Incidents{}
+	for _, i := range all {
+		if i.Ongoing() {
+			i.Duration = now.Sub(i.StartDate)
+		}
+		if i.StartDate.Before(q.Timeframe.EndDate) && (i.Ongoing() || !i.EndDate.Before(q.Timeframe.StartDate)) {
+			incidents = append(incidents, i)
+		}
+	}
+	return incidents
+}
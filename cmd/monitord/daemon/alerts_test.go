@@ -6,6 +6,7 @@ package daemon
 
 import (
 	"fmt"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -15,10 +16,10 @@ import (
 
 const testURL = "http://test/"
 
-// End-to-end test of alert logic
+// Test of the alert state machine
 //
-// It simulates RPC calls from the client and checks
-// if the response is correct.
+// It simulates alert checks by the AlertEngine and checks
+// if the generated alert is correct.
 func TestAlerts(t *testing.T) {
 	end := time.Now()
 	start := end.Add(-20 * time.Second)
@@ -38,93 +39,191 @@ func TestAlerts(t *testing.T) {
 
 	// Create table of test cases
 	testCases := []struct {
-		handler  Handler
-		expected payload.Alerts
+		website  Website
+		expected *payload.Alert
 	}{
 		{
 			// Website was up and is now down: alert expected
-			buildHandler(false, failure, success),
-			buildAlerts(timeframe, 0.5, true),
+			buildWebsite(false, failure, success),
+			buildAlert(timeframe, 0.5, true),
 		},
 		{
 			// Website was down and is now up: alert expected
-			buildHandler(true, success),
-			buildAlerts(timeframe, 1, false),
+			buildWebsite(true, success),
+			buildAlert(timeframe, 1, false),
 		},
 		{
 			// Website was up and is still up: no alert expected
-			buildHandler(false, success),
-			payload.Alerts{},
+			buildWebsite(false, success),
+			nil,
 		},
 		{
 			// Website was down and is still down: no alert expected
-			buildHandler(true, failure),
-			payload.Alerts{},
+			buildWebsite(true, failure),
+			nil,
 		},
 		{
 			// Website has no poll result available: alert expected
-			buildHandler(false),
-			buildAlerts(timeframe, 0, true),
+			buildWebsite(false),
+			buildAlert(timeframe, 0, true),
 		},
 		{
 			// Website has an old poll result: should be ignored in the availability calculation
-			buildHandler(false, beforeStartSuccess, success, failure),
-			buildAlerts(timeframe, 0.5, true),
+			buildWebsite(false, beforeStartSuccess, success, failure),
+			buildAlert(timeframe, 0.5, true),
 		},
 		{
 			// Website has a poll result at the start edge of the timeframe:
 			// should be included in the availability calculation
-			buildHandler(false, edgeStartSuccess, failure),
-			buildAlerts(timeframe, 0.5, true),
+			buildWebsite(false, edgeStartSuccess, failure),
+			buildAlert(timeframe, 0.5, true),
 		},
 		{
 			// Website has a poll result at the end edge of the timeframe:
 			// should be ignored in the availability calculation
-			buildHandler(false, success, failure, edgeEndSuccess),
-			buildAlerts(timeframe, 0.5, true),
+			buildWebsite(false, success, failure, edgeEndSuccess),
+			buildAlert(timeframe, 0.5, true),
 		},
 		{
 			// Website has a poll result that is newer than the end of the timeframe:
 			// should be ignored in the availability calculation
-			buildHandler(false, success, failure, afterEndSuccess),
-			buildAlerts(timeframe, 0.5, true),
+			buildWebsite(false, success, failure, afterEndSuccess),
+			buildAlert(timeframe, 0.5, true),
 		},
 	}
 
 	// Run tests
 	for i, tc := range testCases {
 		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
-			// Simulate an RPC call to Alerts()
-			var computed payload.Alerts
-			tc.handler.Alerts(timeframe, &computed)
+			// Simulate an alert check by the AlertEngine
+			computed, ok := tc.website.CheckAlert(timeframe)
 
 			// Check the result
-			if !reflect.DeepEqual(computed, tc.expected) {
-				t.Errorf("Expected %v, got %v", tc.expected, computed)
+			if ok != (tc.expected != nil) {
+				t.Fatalf("Expected alert %v, got alert %v", tc.expected != nil, ok)
+			}
+			if ok && !reflect.DeepEqual(computed, *tc.expected) {
+				t.Errorf("Expected %v, got %v", *tc.expected, computed)
 			}
 		})
 	}
 }
 
-// buildHandler is a helper function to build test cases.
-// It returns a handler for a single website, with the poll results provided in argument.
-func buildHandler(DownAlertSent bool, r ...PollResult) Handler {
-	return Handler([]Website{Website{
+// End-to-end test of alert reading
+//
+// It simulates RPC calls from two clients and checks that
+// both of them receive the same alerts.
+func TestAlertsMultipleClients(t *testing.T) {
+	end := time.Now()
+	timeframe := payload.Timeframe{StartDate: end.Add(-20 * time.Second), EndDate: end, Seconds: 20}
+	failure := PollResult{Date: end.Add(-1 * time.Second), StatusCode: 500}
+
+	e := &AlertEngine{Websites: Websites{buildWebsite(false, failure)}, History: &AlertHistory{}}
+	if generated := e.Evaluate(timeframe); len(generated) != 1 {
+		t.Fatalf("Expected 1 alert, got %v", generated)
+	}
+	if generated := e.Evaluate(timeframe); len(generated) != 0 {
+		t.Fatalf("Expected no new alert, got %v", generated)
+	}
+
+	h := Handler{Websites: e.Websites, History: e.History}
+	for i := 0; i < 2; i++ {
+		// Simulate an RPC call to Alerts()
+		var computed payload.Alerts
+		h.Alerts(payload.NewTimeframe(60), &computed)
+
+		// Check the result
+		if len(computed) != 1 || computed[0].URL != testURL || !computed[0].BelowThreshold {
+			t.Errorf("Client %v: expected one down alert for %v, got %v", i, testURL, computed)
+		}
+	}
+}
+
+// Test of the default alerting settings, for config files without an Alerts section
+func TestAlertEngineDefaults(t *testing.T) {
+	e := NewAlertEngine(nil, &Config{})
+	if e.Interval != DefaultAlertInterval || e.Timespan != DefaultAlertTimespan {
+		t.Errorf("Expected the default interval and timespan, got %v and %v", e.Interval, e.Timespan)
+	}
+}
+
+// Test of alert persistence and incident reconstruction
+//
+// It records alerts in a log file, reloads them as if the daemon
+// had restarted, and checks the resulting incidents.
+func TestAlertHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.log")
+	h, err := OpenAlertHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The type of the first alerts is not set, as in logs written before
+	// certificate alerts were introduced
+	h.Add(payload.Alert{URL: testURL, Availability: 0.5, BelowThreshold: true})
+	h.Add(payload.Alert{URL: testURL, Availability: 1, BelowThreshold: false})
+	h.Add(payload.Alert{URL: "http://other/", Availability: 0, BelowThreshold: true})
+	h.Add(payload.Alert{Type: payload.CertificateAlert, URL: testURL, Threshold: 14, BelowThreshold: true})
+	h.file.Close()
+
+	// Reload the history from disk
+	h, err = OpenAlertHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.items) != 4 {
+		t.Fatalf("Expected 4 alerts to be reloaded, got %v", len(h.items))
+	}
+	if a, ok := h.Last(testURL, payload.AvailabilityAlert); !ok || a.BelowThreshold {
+		t.Errorf("Expected the latest availability alert to be a recovery alert, got %v", a)
+	}
+
+	// Check incidents of all websites (certificate alerts are not incidents)
+
+	now := time.Now()
+	incidents := h.Incidents(payload.HistoryQuery{Timeframe: payload.NewTimeframe(60)}, now)
+	if len(incidents) != 2 {
+		t.Fatalf("Expected 2 incidents, got %v", incidents)
+	}
+	if i := incidents[0]; i.URL != testURL || i.Ongoing() || i.Duration != i.EndDate.Sub(i.StartDate) {
+		t.Errorf("Expected a closed incident for %v, got %v", testURL, i)
+	}
+	if i := incidents[1]; !i.Ongoing() || i.Duration != now.Sub(i.StartDate) {
+		t.Errorf("Expected an ongoing incident, got %v", i)
+	}
+
+	// Check incidents filtered by URL
+	q := payload.HistoryQuery{Timeframe: payload.NewTimeframe(60), URL: testURL}
+	if incidents = h.Incidents(q, now); len(incidents) != 1 {
+		t.Errorf("Expected 1 incident for %v, got %v", testURL, incidents)
+	}
+
+	// Check that incidents which ended before the timeframe are ignored
+	q = payload.HistoryQuery{Timeframe: payload.Timeframe{StartDate: now, EndDate: now.Add(time.Second)}}
+	if incidents = h.Incidents(q, now); len(incidents) != 1 || !incidents[0].Ongoing() {
+		t.Errorf("Expected only the ongoing incident, got %v", incidents)
+	}
+}
+
+// buildWebsite is a helper function to build test cases.
+// It returns a single website, with the poll results provided in argument.
+func buildWebsite(DownAlertSent bool, r ...PollResult) Website {
+	return Website{
 		URL:           testURL,
 		Threshold:     0.8,
 		PollResults:   &PollResults{items: r},
 		DownAlertSent: DownAlertSent,
-	}})
+	}
 }
 
-// buildAlerts is a helper function to build test cases.
-// It returns a payload with a single alert, using the data provided in argument.
-func buildAlerts(tf payload.Timeframe, avail float64, belowThreshold bool) payload.Alerts {
-	return payload.Alerts{
-		testURL: payload.Alert{
-			Timeframe:      tf,
-			Availability:   avail,
-			BelowThreshold: belowThreshold,
-		},
+// buildAlert is a helper function to build test cases.
+// It returns a single alert, using the data provided in argument.
+func buildAlert(tf payload.Timeframe, avail float64, belowThreshold bool) *payload.Alert {
+	return &payload.Alert{
+		Type:           payload.AvailabilityAlert,
+		URL:            testURL,
+		Timeframe:      tf,
+		Availability:   avail,
+		Threshold:      0.8,
+		BelowThreshold: belowThreshold,
 	}
 }
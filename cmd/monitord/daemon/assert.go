@@ -0,0 +1,201 @@
+/*
+This file contains the body assertion logic, namely:
+- how assertions are built from the config file
+- how response bodies are checked against them
+- how simple JSONPath expressions are evaluated
+*/
+
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An Assertion is a condition that the body of a response must satisfy
+// for the poll result to be valid, e.g. a 200 maintenance page should not
+// count as available.
+type Assertion struct {
+	Config AssertionConfig
+	regex  *regexp.Regexp // Compiled Config.Regex, if set
+	path   []interface{}  // Parsed Config.JSONPath, if set: each element is either a key (string) or an index (int)
+	equals interface{}    // Decoded Config.Equals, if set
+	// hasEquals is true if Config.Equals is set, since equals is also nil
+	// when the expected value is null
+	hasEquals bool
+}
+
+// NewAssertions creates assertions from their configuration.
+// It returns an error if an assertion does not set exactly one of Contains,
+// Regex and JSONPath, if it sets Equals without JSONPath, or if a regular
+// expression or a JSONPath expression is invalid.
+func NewAssertions(configs []AssertionConfig) (assertions []Assertion, err error) {
+	for _, c := range configs {
+		n := 0
+		for _, source := range []string{c.Contains, c.Regex, c.JSONPath} {
+			if source != "" {
+				n++
+			}
+		}
+		if n != 1 {
+			return nil, errors.New("exactly one of Contains, Regex or JSONPath must be set")
+		}
+		if len(c.Equals) != 0 && c.JSONPath == "" {
+			return nil, errors.New("Equals requires JSONPath to be set")
+		}
+
+		a := Assertion{Config: c, hasEquals: len(c.Equals) != 0}
+		switch {
+		case c.Regex != "":
+			if a.regex, err = regexp.Compile(c.Regex); err != nil {
+				return nil, err
+			}
+		case c.JSONPath != "":
+			if a.path, err = ParseJSONPath(c.JSONPath); err != nil {
+				return nil, err
+			}
+			if a.hasEquals {
+				if err = json.Unmarshal(c.Equals, &a.equals); err != nil {
+					return nil, fmt.Errorf("invalid value for JSONPath %v: %v", c.JSONPath, err)
+				}
+			}
+		}
+		assertions = append(assertions, a)
+	}
+	return
+}
+
+// CheckBody checks the body against each assertion,
+// and returns an error describing the first failed assertion, if any.
+func CheckBody(assertions []Assertion, body []byte) error {
+	for _, a := range assertions {
+		if err := a.Check(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check returns an error if the body does not satisfy the assertion.
+//
+// The error describes the assertion rather than the body, so that
+// failures of the same assertion are counted together.
+func (a Assertion) Check(body []byte) error {
+	var matched bool
+	var desc string
+	switch {
+	case a.regex != nil:
+		matched = a.regex.Match(body)
+		desc = "match " + strconv.Quote(a.Config.Regex)
+	case a.Config.JSONPath != "":
+		var err error
+		if matched, err = a.checkJSON(body); err != nil {
+			return err
+		}
+		desc = "have " + a.Config.JSONPath
+		if a.hasEquals {
+			desc += " equal to " + string(a.Config.Equals)
+		}
+	default:
+		matched = bytes.Contains(body, []byte(a.Config.Contains))
+		desc = "contain " + strconv.Quote(a.Config.Contains)
+	}
+
+	if matched == a.Config.Negate {
+		if a.Config.Negate {
+			return errors.New("body should not " + desc)
+		}
+		return errors.New("body does not " + desc)
+	}
+	return nil
+}
+
+// checkJSON returns whether the JSON body has a value at the assertion's path,
+// and whether this value is equal to the expected one, if any.
+// It returns an error if the body is not valid JSON.
+func (a Assertion) checkJSON(body []byte) (bool, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return false, errors.New("body is not valid JSON")
+	}
+	value, ok := EvalJSONPath(doc, a.path)
+	if !ok {
+		return false, nil
+	}
+	return !a.hasEquals || reflect.DeepEqual(value, a.equals), nil
+}
+
+// ParseJSONPath parses a simple JSONPath expression, made of keys and array
+// indexes, e.g. "$.status", "$.checks[0].name" or "$['content-type']".
+// "$" alone is the root of the document, and is parsed to an empty path.
+//
+// Wildcards, filters and recursive descent are not supported.
+func ParseJSONPath(expr string) (path []interface{}, err error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("JSONPath %v should start with $", expr)
+	}
+	path = []interface{}{}
+	s := expr[1:]
+	for s != "" {
+		switch {
+		case s[0] == '.':
+			// Key: read until the next separator
+			end := strings.IndexAny(s[1:], ".[")
+			if end == -1 {
+				end = len(s) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in JSONPath %v", expr)
+			}
+			path = append(path, s[1:end+1])
+			s = s[end+1:]
+		case s[0] == '[':
+			end := strings.IndexByte(s, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("missing ] in JSONPath %v", expr)
+			}
+			inner := s[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				path = append(path, inner[1:len(inner)-1]) // Quoted key
+			} else if i, err := strconv.Atoi(inner); err == nil && i >= 0 {
+				path = append(path, i) // Array index
+			} else {
+				return nil, fmt.Errorf("invalid index %v in JSONPath %v", inner, expr)
+			}
+			s = s[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected character %q in JSONPath %v", s[0], expr)
+		}
+	}
+	return
+}
+
+// EvalJSONPath returns the value at the path in a decoded JSON document,
+// and whether such a value exists.
+func EvalJSONPath(doc interface{}, path []interface{}) (interface{}, bool) {
+	for _, elem := range path {
+		switch elem := elem.(type) {
+		case string:
+			obj, ok := doc.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if doc, ok = obj[elem]; !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := doc.([]interface{})
+			if !ok || elem >= len(arr) {
+				return nil, false
+			}
+			doc = arr[elem]
+		}
+	}
+	return doc, true
+}
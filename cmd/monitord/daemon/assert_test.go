@@ -0,0 +1,101 @@
+/*
+This file contains tests for the body assertion logic.
+*/
+
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Test of body assertions
+func TestAssertions(t *testing.T) {
+	body := []byte(`{"status": "ok", "checks": [{"name": "db", "latency": 3}], "content-type": "json"}`)
+
+	// Create table of test cases
+	testCases := []struct {
+		config   AssertionConfig
+		expected string // Expected error, or "" if the assertion should pass
+	}{
+		{AssertionConfig{Contains: `"ok"`}, ""},
+		{AssertionConfig{Contains: "maintenance"}, `body does not contain "maintenance"`},
+		{AssertionConfig{Contains: "maintenance", Negate: true}, ""},
+		{AssertionConfig{Regex: `"latency": \d+`}, ""},
+		{AssertionConfig{Regex: `"status": "o`, Negate: true}, `body should not match "\"status\": \"o"`},
+		{AssertionConfig{JSONPath: "$.status", Equals: json.RawMessage(`"ok"`)}, ""},
+		{AssertionConfig{JSONPath: "$.checks[0].latency", Equals: json.RawMessage(`3`)}, ""},
+		{AssertionConfig{JSONPath: "$['content-type']"}, ""},
+		{AssertionConfig{JSONPath: "$.checks[1]"}, "body does not have $.checks[1]"},
+		{AssertionConfig{JSONPath: "$.status", Equals: json.RawMessage(`"down"`)}, `body does not have $.status equal to "down"`},
+		{AssertionConfig{JSONPath: "$"}, ""}, // Root of the document
+		{AssertionConfig{JSONPath: "$", Equals: json.RawMessage(`"ok"`)}, `body does not have $ equal to "ok"`},
+		{AssertionConfig{JSONPath: "$.status", Equals: json.RawMessage(`null`)}, "body does not have $.status equal to null"},
+		{AssertionConfig{JSONPath: "$.status", Equals: json.RawMessage(`null`), Negate: true}, ""},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			assertions, err := NewAssertions([]AssertionConfig{tc.config})
+			if err != nil {
+				t.Fatal(err)
+			}
+			computed := ""
+			if err := CheckBody(assertions, body); err != nil {
+				computed = err.Error()
+			}
+			if computed != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, computed)
+			}
+		})
+	}
+}
+
+// Test of invalid assertions, which should be rejected on startup
+func TestInvalidAssertions(t *testing.T) {
+	for _, c := range []AssertionConfig{
+		{},
+		{Regex: "("},
+		{JSONPath: "status"},
+		{JSONPath: "$.checks[x]"},
+		{JSONPath: "$.status", Equals: json.RawMessage(`{`)},
+		{Contains: "ok", Regex: "ok"},
+		{Regex: "ok", JSONPath: "$.status"},
+		{Contains: "ok", Equals: json.RawMessage(`"ok"`)},
+	} {
+		if _, err := NewAssertions([]AssertionConfig{c}); err == nil {
+			t.Errorf("Expected an error for %+v, got nil", c)
+		}
+	}
+}
+
+// Test of a poll whose response body fails an assertion
+//
+// A 200 maintenance page should make the poll result invalid.
+func TestPollAssertion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<h1>Down for maintenance</h1>"))
+	}))
+	defer server.Close()
+
+	assertions, _ := NewAssertions([]AssertionConfig{{Contains: "maintenance", Negate: true}})
+	w := Website{URL: server.URL, PollResults: &PollResults{}, Checker: &HTTPChecker{URL: server.URL, Method: "GET", Assertions: assertions}}
+	w.Poll()
+
+	p := w.PollResults.Extract(payload.NewTimeframe(10))
+	if len(p) != 1 {
+		t.Fatalf("Expected 1 poll result, got %v", p)
+	}
+	if p[0].StatusCode != 200 || p[0].Error == nil || p[0].Error.Error() != `body should not contain "maintenance"` {
+		t.Errorf("Unexpected poll result: %+v", p[0])
+	}
+	if IsValid(p[0]) {
+		t.Error("Expected poll result to be invalid")
+	}
+}
@@ -0,0 +1,199 @@
+/*
+This file contains the authentication logic, namely:
+- how secrets are read from environment variables or files
+- how requests are authenticated using basic auth or a bearer token
+- how OAuth2 tokens are fetched and cached
+*/
+
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Authenticator adds credentials to the requests sent to a website.
+type Authenticator interface {
+	// Authenticate adds credentials to the request, e.g. in its Authorization header.
+	Authenticate(req *http.Request) error
+}
+
+// NewAuthenticator creates the Authenticator defined in the config file,
+// or returns nil if no authentication is configured.
+//
+// Secrets are read once to check that they are available, and then
+// read again each time they are used, so that they can be rotated
+// without restarting the daemon.
+func NewAuthenticator(c AuthConfig) (Authenticator, error) {
+	var a Authenticator
+	var secrets []SecretConfig
+	switch c.Type {
+	case "":
+		return nil, nil
+	case "basic":
+		a = &BasicAuth{Username: c.Username, Password: c.Password}
+		secrets = []SecretConfig{c.Password}
+	case "bearer":
+		a = &BearerAuth{Token: c.Token}
+		secrets = []SecretConfig{c.Token}
+	case "oauth2":
+		if c.TokenURL == "" {
+			return nil, errors.New("oauth2 authentication requires a TokenURL")
+		}
+		a = NewOAuth2Auth(c)
+		secrets = []SecretConfig{c.ClientSecret}
+	default:
+		return nil, fmt.Errorf("unknown authentication type %q", c.Type)
+	}
+	for _, s := range secrets {
+		if _, err := s.Value(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Value returns the secret, read from the environment variable or from the file.
+// Trailing newlines are removed from files.
+func (s SecretConfig) Value() (string, error) {
+	switch {
+	case s.Env != "":
+		v, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %v is not set", s.Env)
+		}
+		return v, nil
+	case s.File != "":
+		data, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	default:
+		return "", errors.New("secret must define Env or File")
+	}
+}
+
+// BasicAuth authenticates requests using HTTP basic authentication.
+type BasicAuth struct {
+	Username string
+	Password SecretConfig
+}
+
+// Authenticate sets the basic authentication credentials of the request.
+func (a *BasicAuth) Authenticate(req *http.Request) error {
+	password, err := a.Password.Value()
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(a.Username, password)
+	return nil
+}
+
+// BearerAuth authenticates requests using a static bearer token.
+type BearerAuth struct {
+	Token SecretConfig
+}
+
+// Authenticate sets the bearer token of the request.
+func (a *BearerAuth) Authenticate(req *http.Request) error {
+	token, err := a.Token.Value()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// OAuth2Auth authenticates requests using a bearer token obtained
+// with the OAuth2 client credentials grant.
+//
+// The token is cached, and a new one is requested shortly before it expires.
+type OAuth2Auth struct {
+	Config AuthConfig
+	Client *http.Client // Client used to request tokens
+
+	mu      sync.Mutex // Protects the fields below
+	token   string     // Cached access token
+	expires time.Time  // Date after which the cached token should not be used. If zero, the token never expires
+}
+
+// OAuth2ExpiryMargin is the duration before the expiry of a token
+// after which a new token is requested.
+const OAuth2ExpiryMargin = 30 * time.Second
+
+// NewOAuth2Auth creates a new OAuth2Auth.
+func NewOAuth2Auth(c AuthConfig) *OAuth2Auth {
+	return &OAuth2Auth{
+		Config: c,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Authenticate sets the bearer token of the request,
+// requesting a new token if the cached one has expired.
+func (a *OAuth2Auth) Authenticate(req *http.Request) error {
+	token, err := a.Token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Token returns the cached token if it is still valid,
+// or requests a new one from the token endpoint otherwise.
+func (a *OAuth2Auth) Token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && (a.expires.IsZero() || time.Now().Before(a.expires)) {
+		return a.token, nil
+	}
+
+	secret, err := a.Config.ClientSecret.Value()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.Config.Scopes) != 0 {
+		form.Set("scope", strings.Join(a.Config.Scopes, " "))
+	}
+	req, err := http.NewRequest("POST", a.Config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.Config.ClientID), url.QueryEscape(secret))
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not get OAuth2 token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not get OAuth2 token: token endpoint replied with response code %v", resp.StatusCode)
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"` // Lifetime of the token, in seconds
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&t); err != nil || t.AccessToken == "" {
+		return "", errors.New("could not get OAuth2 token: invalid response from token endpoint")
+	}
+
+	a.token = t.AccessToken
+	a.expires = time.Time{}
+	if t.ExpiresIn != 0 {
+		a.expires = time.Now().Add(time.Duration(t.ExpiresIn)*time.Second - OAuth2ExpiryMargin)
+	}
+	return a.token, nil
+}
@@ -0,0 +1,124 @@
+/*
+This file contains tests for the authentication logic.
+*/
+
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test of basic and bearer authentication
+//
+// Secrets are read from an environment variable and from a file.
+func TestStaticAuth(t *testing.T) {
+	os.Setenv("MONITOR_TEST_PASSWORD", "s3cret")
+	defer os.Unsetenv("MONITOR_TEST_PASSWORD")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	ioutil.WriteFile(tokenFile, []byte("t0ken\n"), 0600)
+
+	// Create table of test cases
+	testCases := []struct {
+		config   AuthConfig
+		expected string // Expected Authorization header
+	}{
+		{
+			AuthConfig{Type: "basic", Username: "monitor", Password: SecretConfig{Env: "MONITOR_TEST_PASSWORD"}},
+			"Basic bW9uaXRvcjpzM2NyZXQ=",
+		},
+		{
+			AuthConfig{Type: "bearer", Token: SecretConfig{File: tokenFile}},
+			"Bearer t0ken",
+		},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			a, err := NewAuthenticator(tc.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, _ := http.NewRequest("GET", testURL, nil)
+			if err = a.Authenticate(req); err != nil {
+				t.Fatal(err)
+			}
+			if computed := req.Header.Get("Authorization"); computed != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, computed)
+			}
+		})
+	}
+}
+
+// Test of invalid authentication settings, which should be rejected on startup
+func TestInvalidAuth(t *testing.T) {
+	for _, c := range []AuthConfig{
+		{Type: "digest"},
+		{Type: "basic", Username: "monitor"},
+		{Type: "bearer", Token: SecretConfig{Env: "MONITOR_TEST_UNSET"}},
+		{Type: "bearer", Token: SecretConfig{File: "/nonexistent/token"}},
+		{Type: "oauth2", ClientSecret: SecretConfig{Env: "PATH"}},
+	} {
+		if _, err := NewAuthenticator(c); err == nil {
+			t.Errorf("Expected an error for %+v, got nil", c)
+		}
+	}
+}
+
+// Test of OAuth2 authentication
+//
+// The token should be fetched once, cached, and fetched again once expired.
+func TestOAuth2Auth(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		id, secret, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read health" ||
+			id != "monitord" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": "token-%v", "token_type": "bearer", "expires_in": 3600}`, requests)
+	}))
+	defer server.Close()
+
+	os.Setenv("MONITOR_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("MONITOR_TEST_SECRET")
+	a, err := NewAuthenticator(AuthConfig{
+		Type:         "oauth2",
+		TokenURL:     server.URL,
+		ClientID:     "monitord",
+		ClientSecret: SecretConfig{Env: "MONITOR_TEST_SECRET"},
+		Scopes:       []string{"read", "health"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticate := func() string {
+		req, _ := http.NewRequest("GET", testURL, nil)
+		if err := a.Authenticate(req); err != nil {
+			t.Fatal(err)
+		}
+		return req.Header.Get("Authorization")
+	}
+	if h1, h2 := authenticate(), authenticate(); h1 != "Bearer token-1" || h2 != h1 {
+		t.Errorf("Expected the token to be cached, got %q and %q", h1, h2)
+	}
+
+	// Simulate the expiry of the token
+	a.(*OAuth2Auth).expires = time.Now().Add(-time.Second)
+	if h := authenticate(); h != "Bearer token-2" {
+		t.Errorf("Expected a new token, got %q", h)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 token requests, got %v", requests)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+This file contains the certificate monitoring logic, namely:
+- how the TLS certificate chain of a website is recorded at each poll
+- how certificate expiry alerts are generated
+*/
+
+package daemon
+
+import (
+	"crypto/tls"
+	"math"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// NewCertificate summarizes the certificate chain presented by the server
+// during a TLS handshake, and checks it against the host name of the website.
+// It returns nil if the server did not present any certificate.
+func NewCertificate(cs tls.ConnectionState, host string) *payload.Certificate {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := cs.PeerCertificates[0]
+	c := &payload.Certificate{
+		Subject:       leaf.Subject.CommonName,
+		Issuer:        leaf.Issuer.CommonName,
+		SANs:          leaf.DNSNames,
+		NotAfter:      leaf.NotAfter,
+		ChainNotAfter: leaf.NotAfter,
+		HostnameMatch: leaf.VerifyHostname(host) == nil,
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		if cert.NotAfter.Before(c.ChainNotAfter) {
+			c.ChainNotAfter = cert.NotAfter
+		}
+	}
+	return c
+}
+
+// LatestCertificate returns a copy of the certificate of the latest poll result
+// that has one, with its number of days until expiry computed as of now.
+// It returns nil if no poll result has a certificate.
+func LatestCertificate(p []PollResult, now time.Time) *payload.Certificate {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i].Certificate != nil {
+			c := *p[i].Certificate
+			c.DaysUntilExpiry = int(math.Floor(c.ChainNotAfter.Sub(now).Hours() / 24))
+			return &c
+		}
+	}
+	return nil
+}
+
+// CheckCertificate compares the number of days until the expiry of the
+// website's latest certificate (over the specified timeframe) against the
+// website's warning period.
+//
+// If the certificate entered the warning period, or was renewed since the
+// last check, it returns the corresponding alert and true. Otherwise, or if
+// certificate alerts are disabled for the website, it returns false.
+func (w *Website) CheckCertificate(tf payload.Timeframe) (payload.Alert, bool) {
+	if w.CertificateDays == 0 {
+		return payload.Alert{}, false
+	}
+	c := LatestCertificate(w.PollResults.Extract(tf), tf.EndDate)
+	if c == nil {
+		return payload.Alert{}, false
+	}
+
+	expiring := c.DaysUntilExpiry < w.CertificateDays
+	if expiring == w.CertAlertSent {
+		return payload.Alert{}, false
+	}
+	w.CertAlertSent = expiring
+	return payload.Alert{
+		Type:           payload.CertificateAlert,
+		URL:            w.URL,
+		Timeframe:      tf,
+		Threshold:      float64(w.CertificateDays),
+		BelowThreshold: expiring,
+		Certificate:    c,
+	}, true
+}
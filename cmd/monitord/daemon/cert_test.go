@@ -0,0 +1,112 @@
+/*
+This file contains tests for the certificate monitoring logic.
+*/
+
+package daemon
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Test of the recording of certificate chains
+func TestNewCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	conn, err := tls.Dial("tcp", strings.TrimPrefix(server.URL, "https://"), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cs := conn.ConnectionState()
+	leaf := server.Certificate()
+
+	c := NewCertificate(cs, "example.com")
+	if c == nil || !c.HostnameMatch || !c.NotAfter.Equal(leaf.NotAfter) || len(c.SANs) != len(leaf.DNSNames) {
+		t.Errorf("Unexpected certificate: %+v", c)
+	}
+	if c = NewCertificate(cs, "other.org"); c.HostnameMatch {
+		t.Error("Expected the host name not to match")
+	}
+	if c = NewCertificate(tls.ConnectionState{}, "example.com"); c != nil {
+		t.Errorf("Expected no certificate, got %+v", c)
+	}
+}
+
+// Test of certificate expiry alerts
+func TestCheckCertificate(t *testing.T) {
+	tf := payload.NewTimeframe(20)
+	result := func(days int) PollResult {
+		expiry := tf.EndDate.Add(time.Duration(days)*24*time.Hour + time.Hour)
+		return PollResult{Date: tf.EndDate.Add(-time.Second), Certificate: &payload.Certificate{ChainNotAfter: expiry}}
+	}
+
+	// Create table of test cases
+	testCases := []struct {
+		results       []PollResult
+		certAlertSent bool
+		expected      *payload.Alert // Expected alert, or nil if no alert should be generated
+	}{
+		{
+			// No certificate: no alert
+			[]PollResult{{Date: tf.EndDate.Add(-time.Second)}},
+			false,
+			nil,
+		},
+		{
+			// Certificate far from expiry: no alert
+			[]PollResult{result(60)},
+			false,
+			nil,
+		},
+		{
+			// Certificate about to expire: alert, using the latest certificate
+			[]PollResult{result(60), result(10)},
+			false,
+			&payload.Alert{Type: payload.CertificateAlert, URL: testURL, Timeframe: tf, Threshold: 14, BelowThreshold: true},
+		},
+		{
+			// Certificate still about to expire: no repeated alert
+			[]PollResult{result(10)},
+			true,
+			nil,
+		},
+		{
+			// Certificate renewed: recovery alert
+			[]PollResult{result(10), result(90)},
+			true,
+			&payload.Alert{Type: payload.CertificateAlert, URL: testURL, Timeframe: tf, Threshold: 14, BelowThreshold: false},
+		},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			w := Website{URL: testURL, CertificateDays: 14, PollResults: &PollResults{items: tc.results}, CertAlertSent: tc.certAlertSent}
+			a, ok := w.CheckCertificate(tf)
+			if tc.expected == nil {
+				if ok {
+					t.Errorf("Expected no alert, got %v", a)
+				}
+				return
+			}
+			last := tc.results[len(tc.results)-1].Certificate.ChainNotAfter
+			days := int(last.Sub(tf.EndDate).Hours() / 24)
+			if !ok || a.Certificate == nil || a.Certificate.DaysUntilExpiry != days {
+				t.Fatalf("Expected an alert with %v days until expiry, got %v", days, a)
+			}
+			a.Certificate = nil
+			if a != *tc.expected {
+				t.Errorf("Expected %v, got %v", *tc.expected, a)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/*
+This file contains the check types logic, namely:
+- the Checker interface implemented by each type of check
+- the registry from which checkers are created according to the config file
+*/
+
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+// A Checker probes a website using a given protocol (HTTP, TCP, DNS...).
+//
+// Checkers only produce poll results: scheduling, policy checks, storage
+// and aggregation are the same for every type of check.
+type Checker interface {
+	// Check probes the website once, and returns the corresponding poll result.
+	// Failures are reported in the Error field of the poll result.
+	Check() PollResult
+}
+
+// A CheckerFactory creates the Checker of a website from its configuration.
+// It returns an error if the configuration is invalid for this type of check.
+type CheckerFactory func(c WebsiteConfig) (Checker, error)
+
+// Checkers maps from a check type (the Type field of WebsiteConfig)
+// to the factory creating the corresponding checkers.
+//
+// Each type of check registers itself with RegisterChecker.
+var Checkers = make(map[string]CheckerFactory)
+
+// DefaultCheckType is the type of check used when Type is not set in the config file.
+const DefaultCheckType = "http"
+
+// RegisterChecker makes a type of check available in the config file.
+// It is meant to be called from the init function of the file implementing the check.
+func RegisterChecker(typ string, f CheckerFactory) {
+	if _, ok := Checkers[typ]; ok {
+		panic("check type registered twice: " + typ)
+	}
+	Checkers[typ] = f
+}
+
+// NewChecker creates the Checker of a website, according to its type of check.
+func NewChecker(c WebsiteConfig) (Checker, error) {
+	typ := c.Type
+	if typ == "" {
+		typ = DefaultCheckType
+	}
+	f, ok := Checkers[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown check type %v", typ)
+	}
+	return f(c)
+}
+
+// ReadBody returns the request body or payload of a website,
+// read from BodyFile if it is set, or from Body otherwise.
+func ReadBody(c WebsiteConfig) ([]byte, error) {
+	if c.BodyFile != "" {
+		return ioutil.ReadFile(c.BodyFile)
+	}
+	return []byte(c.Body), nil
+}
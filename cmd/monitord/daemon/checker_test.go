@@ -0,0 +1,74 @@
+/*
+This file contains tests for the check types logic.
+*/
+
+package daemon
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Test of the creation of checkers according to the type of check
+func TestNewChecker(t *testing.T) {
+	// Create table of test cases
+	testCases := []struct {
+		config   WebsiteConfig
+		expected Checker // Type of the expected checker, or nil if an error is expected
+	}{
+		{WebsiteConfig{URL: "https://example.com"}, &HTTPChecker{}},
+		{WebsiteConfig{URL: "https://example.com", Type: "http"}, &HTTPChecker{}},
+		{WebsiteConfig{URL: "tcp://db.internal:5432", Type: "tcp"}, &TCPChecker{}},
+		{WebsiteConfig{URL: "dns://1.1.1.1/example.com", Type: "dns"}, &DNSChecker{}},
+		{WebsiteConfig{URL: "https://example.com", Type: "tcp"}, nil},
+		{WebsiteConfig{URL: "https://example.com", Type: "ftp"}, nil},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			c, err := NewChecker(tc.config)
+			if tc.expected == nil {
+				if err == nil {
+					t.Errorf("Expected an error, got %T", c)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reflect.TypeOf(c) != reflect.TypeOf(tc.expected) {
+				t.Errorf("Expected %T, got %T", tc.expected, c)
+			}
+		})
+	}
+}
+
+// Test of a custom type of check, which is polled and aggregated like any other
+func TestRegisterChecker(t *testing.T) {
+	RegisterChecker("test", func(c WebsiteConfig) (Checker, error) {
+		return CheckerFunc(func() PollResult { return PollResult{Date: time.Now(), StatusCode: 200} }), nil
+	})
+	defer delete(Checkers, "test")
+
+	c, err := NewChecker(WebsiteConfig{URL: "test://example", Type: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := Website{URL: "test://example", PollResults: &PollResults{}, Checker: c}
+	w.Poll()
+	p := w.PollResults.Extract(payload.NewTimeframe(10))
+	if len(p) != 1 || !IsValid(p[0]) {
+		t.Errorf("Unexpected poll results: %+v", p)
+	}
+}
+
+// CheckerFunc allows the use of an ordinary function as a Checker in tests.
+type CheckerFunc func() PollResult
+
+// Check calls f.
+func (f CheckerFunc) Check() PollResult { return f() }
@@ -14,10 +14,41 @@ import (
 // Config represents the user-defined configuration of the daemon.
 type Config struct {
 	ListeningPort int // Port on which the RPC server listens
-	Default       struct {
-		Interval        int     // Interval, in seconds, between two polls to a given website
-		RetainedResults int     // Number of poll results that should be kept. If set to 0, no poll result is ever deleted
-		Threshold       float64 // Availability threshold that should trigger an alert when crossed
+	Alerts        struct {
+		Interval int    // Interval, in seconds, between two evaluations of websites' availability. If set to 0, a 4 seconds interval is used
+		Timespan int    // Timespan, in seconds, over which availability is computed. If set to 0, a 120 seconds timespan is used
+		Log      string // Path to the file in which alerts are persisted. If empty, alerts are only kept in memory
+	}
+	Notifications struct {
+		Webhook WebhookConfig // Settings used to call the webhook endpoints
+		Email   EmailConfig   // Settings used to send alerts by email
+		Exec    ExecConfig    // Settings used to run a local command on each alert
+	}
+	Storage struct {
+		Path        string // Directory in which poll results are persisted. If empty, poll results are only kept in memory
+		SegmentSize int    // Number of poll results per segment file. If set to 0, segments of 1000 poll results are used
+	}
+	// Upper bounds, in milliseconds, of the buckets used to compute the distribution
+	// of response times, e.g. [100, 300, 800]. A last bucket with no upper bound is added.
+	Histogram []int
+	Retention struct {
+		// Duration, in seconds, during which poll results are kept, in addition to
+		// the RetainedResults limit. If set to 0, poll results never expire.
+		// It must be longer than the resolution of the finest rollup, plus 30 seconds.
+		Raw     int
+		Rollups []RollupConfig // Rollups computed from poll results to answer long timeframes
+	}
+	Default struct {
+		Interval        int               // Interval, in seconds, between two polls to a given website
+		RetainedResults int               // Number of poll results that should be kept. If set to 0, no poll result is ever deleted
+		Threshold       float64           // Availability threshold that should trigger an alert when crossed
+		CertificateDays int               // Number of days before certificate expiry under which an alert is raised. If set to 0, no alert is raised
+		Webhooks        []string          // Endpoints to which alerts are posted
+		Retries         int               // Number of times a failed poll is retried before its failure is recorded
+		RetryDelay      int               // Delay, in milliseconds, between two attempts of a poll
+		Policy          PolicyConfig      // Additional criteria that responses must satisfy to be considered valid
+		Timeouts        TimeoutConfig     // Timeouts of the requests made to websites
+		Headers         map[string]string // Headers sent with each request
 	}
 	Websites []WebsiteConfig // List of websites to poll
 }
@@ -25,11 +56,179 @@ type Config struct {
 // WebsiteConfig represents the configuration of a specific website.
 type WebsiteConfig struct {
 	URL string
+	// Type of check: "http" (default), "tcp", in which case URL is of the form tcp://host:port,
+	// "dns", in which case URL is of the form dns://server[:port]/name[?type=A|AAAA|CNAME|MX|TXT],
+	// "grpc", in which case URL is of the form grpc[s]://host:port[/service],
+	// "websocket", in which case URL is of the form ws[s]://host[:port]/path,
+	// or "journey", in which case Steps are run in order, with relative URLs resolved against URL
+	Type string
 
-	// If Interval, RetainedResults or Threshold are not filled, Config.Default will be used instead
+	// If Interval, RetainedResults, Threshold, CertificateDays, Webhooks, Retries or RetryDelay
+	// are not filled, Config.Default will be used instead. The same applies to each criterion of Policy,
+	// and to each timeout of Timeouts.
 	Interval        int
 	RetainedResults int
 	Threshold       float64
+	CertificateDays int
+	Webhooks        []string
+	Retries         int
+	RetryDelay      int
+	Policy          PolicyConfig
+
+	// Assertions that the response body must satisfy for the poll result to be valid
+	Assertions []AssertionConfig
+
+	// Request sent to the website. Headers are added to Config.Default.Headers,
+	// and "Host" overrides the host of the URL.
+	Method   string            // HTTP method. If empty, GET is used
+	Headers  map[string]string // Headers sent with each request, or metadata sent with each gRPC call
+	Body     string            // Request body, or payload (TCP checks) or message (WebSocket checks) sent on the connection
+	BodyFile string            // Path to a file containing the request body, used instead of Body
+	Expect   string            // Regular expression that the response (TCP checks), the answers, one per line (DNS checks), or the reply (WebSocket checks) must match
+
+	// Time, in milliseconds, during which the reply of WebSocket checks is awaited. If 0, 4 seconds are used
+	ReplyTimeout int
+
+	Auth      AuthConfig     // Credentials added to each request
+	TLS       TLSConfig      // TLS settings of the connections to the website
+	Redirects RedirectConfig // How redirect responses are handled
+	Timeouts  TimeoutConfig  // Timeouts of the requests made to the website
+
+	// Requests made by journey checks, in order
+	Steps []StepConfig
+}
+
+// TimeoutConfig defines the timeouts, in milliseconds, of the requests made to a website.
+// They apply to HTTP, gRPC and journey checks, and to the handshake of WebSocket checks.
+// TCP and DNS checks only use Overall and Connect.
+type TimeoutConfig struct {
+	Overall int // Maximum duration of a request (or of the whole redirect chain), from the DNS lookup to the end of the body. If set to 0, 10 seconds are used
+	Connect int // Maximum duration of the DNS lookup and TCP connection. If set to 0, 4 seconds are used
+	TLS     int // Maximum duration of the TLS handshake. If set to 0, 4 seconds are used
+	Header  int // Maximum time between the end of the request and the response headers. If set to 0, only Overall applies
+	Body    int // Maximum time to read the response body. If set to 0, only Overall applies
+}
+
+// RedirectConfig defines how the redirect responses of a website are handled.
+// By default, redirects are not followed, and the redirect response is recorded as is.
+type RedirectConfig struct {
+	Max      int    // Maximum number of redirects followed. Polls redirected more times are invalid
+	Forbid   bool   // If true, polls receiving a redirect response are invalid. Cannot be used with Max
+	FinalURL string // Regular expression that the URL of the final response must match
+}
+
+// StepConfig defines a step of a journey check, i.e. a request made once the previous steps succeeded.
+//
+// Variables extracted from the responses of the previous steps are substituted
+// in URL, Headers and Body, where they appear as {{name}}.
+// Cookies set by the previous steps are also sent automatically.
+type StepConfig struct {
+	Name       string                   // Name of the step, shown in error messages. If empty, "step N" is used
+	URL        string                   // URL of the request, possibly relative to the URL of the website
+	Method     string                   // HTTP method. If empty, GET is used
+	Headers    map[string]string        // Headers added to those of the website
+	Body       string                   // Request body
+	Assertions []AssertionConfig        // Assertions that the response body must satisfy
+	Extract    map[string]ExtractConfig // Variables extracted from the response, by name
+}
+
+// ExtractConfig defines where the value of a variable is extracted from.
+// Exactly one of its fields must be set.
+type ExtractConfig struct {
+	Header   string // Name of a response header
+	Cookie   string // Name of a cookie set by the response
+	JSONPath string // Path of a field of the JSON response body, e.g. "$.token"
+}
+
+// TLSConfig defines how connections to a website are secured.
+type TLSConfig struct {
+	CAFile             string // Path to a PEM file of CA certificates, trusted in addition to the system ones
+	CertFile           string // Path to the PEM client certificate used for mutual TLS
+	KeyFile            string // Path to the PEM private key of the client certificate
+	ServerName         string // Name used to verify the server certificate, instead of the host of the URL
+	MinVersion         string // Minimum TLS version, e.g. "1.2". If empty, the Go default is used
+	InsecureSkipVerify bool   // If true, the server certificate is not verified. A warning is shown on the dashboard
+}
+
+// AuthConfig defines how requests to a website are authenticated.
+// Secrets are never written in the config file: they are read from
+// environment variables or files instead.
+type AuthConfig struct {
+	Type string // "basic", "bearer" or "oauth2". If empty, requests are not authenticated
+
+	// Basic authentication
+	Username string
+	Password SecretConfig
+
+	// Bearer token
+	Token SecretConfig
+
+	// OAuth2 client credentials grant
+	TokenURL     string // Endpoint from which tokens are requested
+	ClientID     string // Client identifier
+	ClientSecret SecretConfig
+	Scopes       []string // Scopes requested for the token
+}
+
+// SecretConfig defines where a secret is read from.
+// Exactly one of Env and File should be set.
+type SecretConfig struct {
+	Env  string // Name of the environment variable containing the secret
+	File string // Path to the file containing the secret
+}
+
+// AssertionConfig defines a condition on the body of a response.
+// Exactly one of Contains, Regex and JSONPath must be set.
+type AssertionConfig struct {
+	Contains string          // Substring that the body must contain
+	Regex    string          // Regular expression that the body must match
+	JSONPath string          // Path that must exist in the JSON body, e.g. "$.checks[0].status"
+	Equals   json.RawMessage // If set (even to null), the JSON value at JSONPath must be equal to it, e.g. "ok" or 3
+	Negate   bool            // If true, the body must not satisfy the condition
+}
+
+// PolicyConfig defines the criteria that a response must satisfy to be
+// considered valid, in addition to not being a 4xx or 5xx response.
+// Responses that do not satisfy them are reported as policy violations.
+type PolicyConfig struct {
+	MaxResponseTime      int   // Maximum response time, in milliseconds. If set to 0, there is no limit
+	MaxTTFB              int   // Maximum time to first byte, in milliseconds. If set to 0, there is no limit
+	AllowedStatusCodes   []int // If not empty, only these response codes are valid
+	ForbiddenStatusCodes []int // Response codes that are not valid, e.g. [301, 302]
+}
+
+// WebhookConfig defines how alerts are posted to webhook endpoints.
+type WebhookConfig struct {
+	Timeout int // Timeout, in seconds, of each request. If set to 0, a 10 seconds timeout is used
+	Retries int // Number of retries when a request fails
+	Backoff int // Delay, in seconds, before the first retry. It is doubled after each retry
+}
+
+// RollupConfig defines a resolution at which poll results are summarized.
+// Resolutions must be multiples of each other, e.g. 60 and 3600.
+type RollupConfig struct {
+	Resolution int // Duration, in seconds, summarized by each rollup
+	Keep       int // Duration, in seconds, during which rollups are kept. If set to 0, rollups are never deleted
+}
+
+// EmailConfig defines how alerts are sent by email.
+// If Server is empty, no email is sent.
+type EmailConfig struct {
+	Server     string   // Address of the SMTP server, e.g. "smtp.example.com:587"
+	From       string   // Sender address
+	Recipients []string // Recipient addresses
+	StartTLS   bool     // Whether the connection should be upgraded to TLS using STARTTLS
+	Username   string   // Username used for PLAIN authentication. If empty, no authentication is done
+	Password   string
+}
+
+// ExecConfig defines the local command that is run on each alert.
+// If Command is empty, no command is run.
+type ExecConfig struct {
+	Command     string   // Path of the command to run
+	Args        []string // Arguments passed to the command
+	Timeout     int      // Timeout, in seconds, after which the command is killed. If set to 0, a 10 seconds timeout is used
+	Concurrency int      // Maximum number of commands running at the same time. If set to 0, commands are run one at a time
 }
 
 // ReadConfig reads the config file and returns the associated Config object.
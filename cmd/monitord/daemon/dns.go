@@ -0,0 +1,350 @@
+/*
+This file contains the DNS check logic, namely:
+- how DNS check URLs are parsed
+- how DNS queries are encoded and sent to a specific server
+- how DNS responses are decoded and checked
+*/
+
+package daemon
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+func init() {
+	RegisterChecker("dns", NewDNSChecker)
+}
+
+// DNSTypes maps from the record types supported by DNS checks to their code.
+var DNSTypes = map[string]uint16{
+	"A":     1,
+	"CNAME": 5,
+	"MX":    15,
+	"TXT":   16,
+	"AAAA":  28,
+}
+
+// DNSRcodes maps from DNS response codes to their name, for error messages.
+var DNSRcodes = map[int]string{
+	1: "FORMERR",
+	2: "SERVFAIL",
+	3: "NXDOMAIN",
+	4: "NOTIMP",
+	5: "REFUSED",
+}
+
+// A DNSQuery is the query made by a DNS check.
+type DNSQuery struct {
+	Server string // host:port address of the DNS server
+	Name   string // Fully qualified domain name, e.g. "example.com."
+	Type   string // Record type, e.g. "A"
+}
+
+// A DNSAnswer is a record of a DNS response.
+type DNSAnswer struct {
+	Type  uint16
+	Value string // IP address, domain name, "preference host" for MX records, or text
+}
+
+// DNSChecker checks a DNS record.
+type DNSChecker struct {
+	Query      DNSQuery
+	Expect     *regexp.Regexp // Pattern that the answer must match
+	Assertions []Assertion    // Conditions that the answer must satisfy
+	Timeouts   Timeouts       // Timeouts of the query. Only Connect and Overall are used
+}
+
+// NewDNSChecker creates a DNSChecker from the configuration of a website.
+func NewDNSChecker(c WebsiteConfig) (Checker, error) {
+	d := &DNSChecker{Timeouts: NewTimeouts(c.Timeouts)}
+	var err error
+	if d.Query, err = ParseDNSURL(c.URL); err != nil {
+		return nil, err
+	}
+	if d.Expect, err = CompileExpect(c.Expect); err != nil {
+		return nil, err
+	}
+	if d.Assertions, err = NewAssertions(c.Assertions); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ParseDNSURL returns the query described by a DNS check URL,
+// e.g. "dns://1.1.1.1/example.com?type=MX".
+//
+// The port of the server defaults to 53, and the record type to A.
+func ParseDNSURL(rawURL string) (q DNSQuery, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	name := strings.Trim(u.Path, "/")
+	if u.Scheme != "dns" || u.Hostname() == "" || name == "" {
+		return q, fmt.Errorf("DNS checks require a dns://server/name URL, got %v", rawURL)
+	}
+
+	q.Server = u.Host
+	if u.Port() == "" {
+		q.Server = net.JoinHostPort(u.Hostname(), "53")
+	}
+	q.Name = name + "."
+	q.Type = strings.ToUpper(u.Query().Get("type"))
+	if q.Type == "" {
+		q.Type = "A"
+	}
+	if _, ok := DNSTypes[q.Type]; !ok {
+		return q, fmt.Errorf("unsupported DNS record type %v", q.Type)
+	}
+	return
+}
+
+// Check queries the DNS server for the record, and checks
+// the answer against the expected pattern and assertions.
+//
+// The answers are matched one per line. As DNS checks consist of a single
+// round trip, it is recorded as the DNS, TTFB and response times.
+func (c *DNSChecker) Check() (p PollResult) {
+	p.Date = time.Now()
+	answers, err := c.Query.Exchange(c.Timeouts)
+	d := time.Since(p.Date)
+	p.Timing = payload.Timing{DNS: d, TTFB: d, Response: d}
+	if err != nil {
+		p.Error = err
+		return
+	}
+
+	// Keep the records of the requested type (e.g. not the CNAME records preceding A records)
+	var values []string
+	for _, a := range answers {
+		if a.Type == DNSTypes[c.Query.Type] {
+			values = append(values, a.Value)
+		}
+	}
+	if len(values) == 0 {
+		p.Error = fmt.Errorf("no %v record for %v", c.Query.Type, c.Query.Name)
+		return
+	}
+	answer := []byte(strings.Join(values, "\n"))
+	if c.Expect != nil && !c.Expect.Match(answer) {
+		p.Error = fmt.Errorf("answer does not match %q", c.Expect.String())
+		return
+	}
+	p.Error = CheckBody(c.Assertions, answer)
+	return
+}
+
+// Exchange sends the query to the DNS server over UDP, and returns the answers.
+// If the response is truncated, the query is sent again over TCP.
+// The overall timeout applies to the whole exchange.
+func (q DNSQuery) Exchange(t Timeouts) ([]DNSAnswer, error) {
+	id := uint16(rand.Intn(1 << 16))
+	msg := BuildDNSQuery(id, q.Name, DNSTypes[q.Type])
+	var deadline time.Time // Zero if there is no overall timeout
+	if t.Overall != 0 {
+		deadline = time.Now().Add(t.Overall)
+	}
+
+	resp, err := exchangeDNS("udp", q.Server, msg, t, deadline)
+	if err != nil {
+		return nil, err
+	}
+	rcode, truncated, answers, err := ParseDNSResponse(resp, id)
+	if err == nil && truncated {
+		if resp, err = exchangeDNS("tcp", q.Server, msg, t, deadline); err != nil {
+			return nil, err
+		}
+		rcode, _, answers, err = ParseDNSResponse(resp, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rcode != 0 {
+		name, ok := DNSRcodes[rcode]
+		if !ok {
+			name = "rcode " + strconv.Itoa(rcode)
+		}
+		return nil, fmt.Errorf("DNS server replied %v for %v", name, q.Name)
+	}
+	return answers, nil
+}
+
+// exchangeDNS sends a DNS message to the server and returns the response.
+// Over TCP, messages are prefixed with their length.
+func exchangeDNS(network, server string, msg []byte, t Timeouts, deadline time.Time) ([]byte, error) {
+	dialer := net.Dialer{Timeout: t.Connect, Deadline: deadline}
+	conn, err := dialer.Dial(network, server)
+	if err != nil {
+		return nil, t.ClassifyDial(err, deadline)
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	if network == "tcp" {
+		msg = append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...)
+	}
+	if _, err = conn.Write(msg); err != nil {
+		return nil, t.Classify(err, TimeoutOverall)
+	}
+
+	if network == "tcp" {
+		var length [2]byte
+		if _, err = readFull(conn, length[:]); err != nil {
+			return nil, t.Classify(err, TimeoutOverall)
+		}
+		resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+		_, err = readFull(conn, resp)
+		return resp, t.Classify(err, TimeoutOverall)
+	}
+	resp := make([]byte, 65535)
+	n, err := conn.Read(resp)
+	return resp[:n], t.Classify(err, TimeoutOverall)
+}
+
+// readFull reads exactly len(buf) bytes from the connection.
+func readFull(conn net.Conn, buf []byte) (n int, err error) {
+	for n < len(buf) && err == nil {
+		var nn int
+		nn, err = conn.Read(buf[n:])
+		n += nn
+	}
+	return
+}
+
+// BuildDNSQuery encodes a DNS query for a single record, with recursion desired.
+func BuildDNSQuery(id uint16, name string, qtype uint16) []byte {
+	msg := []byte{
+		byte(id >> 8), byte(id),
+		0x01, 0x00, // Flags: recursion desired
+		0x00, 0x01, // One question
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // No answer, authority or additional records
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0x00, byte(qtype>>8), byte(qtype), 0x00, 0x01) // Root label, type, class IN
+}
+
+// ParseDNSResponse decodes a DNS response to the query with the provided id.
+// It returns the response code, whether the response was truncated, and the answers.
+// Answers of unsupported types are ignored.
+func ParseDNSResponse(msg []byte, id uint16) (rcode int, truncated bool, answers []DNSAnswer, err error) {
+	if len(msg) < 12 {
+		return 0, false, nil, errors.New("DNS response is too short")
+	}
+	if binary.BigEndian.Uint16(msg) != id || msg[2]&0x80 == 0 {
+		return 0, false, nil, errors.New("DNS response does not match the query")
+	}
+	truncated = msg[2]&0x02 != 0
+	rcode = int(msg[3] & 0x0f)
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	// Skip the questions
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		if _, off, err = readDNSName(msg, off); err != nil {
+			return
+		}
+		off += 4 // Type and class
+	}
+
+	// Read the answers
+	for i := 0; i < ancount; i++ {
+		if _, off, err = readDNSName(msg, off); err != nil {
+			return
+		}
+		if off+10 > len(msg) {
+			return rcode, truncated, nil, errors.New("DNS response is truncated")
+		}
+		typ := binary.BigEndian.Uint16(msg[off:])
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return rcode, truncated, nil, errors.New("DNS response is truncated")
+		}
+		rdata := msg[off : off+length]
+
+		a := DNSAnswer{Type: typ}
+		switch typ {
+		case DNSTypes["A"], DNSTypes["AAAA"]:
+			a.Value = net.IP(rdata).String()
+		case DNSTypes["CNAME"]:
+			if a.Value, _, err = readDNSName(msg, off); err != nil {
+				return
+			}
+		case DNSTypes["MX"]:
+			if length < 2 {
+				return rcode, truncated, nil, errors.New("invalid MX record")
+			}
+			var host string
+			if host, _, err = readDNSName(msg, off+2); err != nil {
+				return
+			}
+			a.Value = strconv.Itoa(int(binary.BigEndian.Uint16(rdata))) + " " + host
+		case DNSTypes["TXT"]:
+			// TXT records are made of length-prefixed strings, which are concatenated
+			for j := 0; j < len(rdata); j += int(rdata[j]) + 1 {
+				end := j + 1 + int(rdata[j])
+				if end > len(rdata) {
+					return rcode, truncated, nil, errors.New("invalid TXT record")
+				}
+				a.Value += string(rdata[j+1 : end])
+			}
+		default:
+			off += length
+			continue
+		}
+		answers = append(answers, a)
+		off += length
+	}
+	return
+}
+
+// readDNSName reads a possibly compressed domain name at offset off of the message.
+// It returns the name (with a trailing dot) and the offset following it.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1 // Offset following the name, once a compression pointer was followed
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("invalid domain name in DNS response")
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if next == -1 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xc0 == 0xc0:
+			// Compression pointer to a previous name
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("invalid domain name in DNS response")
+			}
+			if next == -1 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errors.New("invalid domain name in DNS response")
+			}
+			labels = append(labels, string(msg[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
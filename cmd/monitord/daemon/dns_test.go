@@ -0,0 +1,159 @@
+/*
+This file contains tests for the DNS check logic.
+*/
+
+package daemon
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test of DNS checks
+//
+// Queries are sent to a local DNS server, which answers from a fixed zone.
+func TestDNSCheck(t *testing.T) {
+	server := serveDNS(t, map[string][]testRecord{
+		"example.test. A":     {{1, net.ParseIP("192.0.2.1").To4()}, {1, net.ParseIP("192.0.2.2").To4()}},
+		"example.test. AAAA":  {{28, net.ParseIP("2001:db8::1")}},
+		"www.example.test. A": {{5, encodeName("example.test.")}, {1, net.ParseIP("192.0.2.1").To4()}},
+		"example.test. MX":    {{15, append([]byte{0, 10}, encodeName("mx.example.test.")...)}},
+		"example.test. TXT":   {{16, append([]byte{11}, "v=spf1 -all"...)}},
+		"empty.test. A":       {},
+	})
+
+	// Get the address of a server that never replies
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	// Create table of test cases
+	testCases := []struct {
+		url      string
+		expect   string
+		expected string // Expected error, or "" if the check should succeed
+	}{
+		{"dns://" + server + "/example.test", `(?m)^192\.0\.2\.2$`, ""},
+		{"dns://" + server + "/example.test?type=AAAA", "2001:db8::1", ""},
+		{"dns://" + server + "/www.example.test", `^192\.0\.2\.1$`, ""}, // The CNAME record is ignored
+		{"dns://" + server + "/example.test?type=MX", "10 mx.example.test.", ""},
+		{"dns://" + server + "/example.test?type=txt", "spf1", ""},
+		{"dns://" + server + "/example.test", "192.0.2.3", `answer does not match "192.0.2.3"`},
+		{"dns://" + server + "/empty.test", "", "no A record for empty.test."},
+		{"dns://" + server + "/unknown.test", "", "DNS server replied NXDOMAIN for unknown.test."},
+		{"dns://" + silent.LocalAddr().String() + "/example.test", "", "overall timeout (500ms)"},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			q, err := ParseDNSURL(tc.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := DNSChecker{Query: q, Timeouts: Timeouts{Overall: 500 * time.Millisecond}}
+			if tc.expect != "" {
+				c.Expect = regexp.MustCompile(tc.expect)
+			}
+			p := c.Check()
+			computed := ""
+			if p.Error != nil {
+				computed = p.Error.Error()
+			}
+			if computed != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, computed)
+			}
+			if p.Timing.Response == 0 || p.Timing.DNS != p.Timing.Response {
+				t.Errorf("Unexpected timings: %+v", p.Timing)
+			}
+		})
+	}
+}
+
+// Test of DNS check URLs
+func TestParseDNSURL(t *testing.T) {
+	q, err := ParseDNSURL("dns://1.1.1.1/example.com")
+	if err != nil || q != (DNSQuery{"1.1.1.1:53", "example.com.", "A"}) {
+		t.Errorf("Unexpected query: %+v (%v)", q, err)
+	}
+	for _, u := range []string{"dns://1.1.1.1", "http://1.1.1.1/example.com", "dns://1.1.1.1/example.com?type=SRV"} {
+		if _, err := ParseDNSURL(u); err == nil {
+			t.Errorf("Expected an error for %v, got nil", u)
+		}
+	}
+}
+
+// testRecord is a record served by the local DNS server.
+type testRecord struct {
+	Type  uint16
+	RData []byte
+}
+
+// serveDNS is a helper function that starts a minimal DNS server over UDP.
+// Records are indexed by name and type, e.g. "example.test. A", and names
+// that are not in the zone get a NXDOMAIN response.
+// It returns the address of the server.
+func serveDNS(t *testing.T, zone map[string][]testRecord) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	types := make(map[uint16]string)
+	for name, code := range DNSTypes {
+		types[code] = name
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := buf[:n]
+			name, off, err := readDNSName(query, 12)
+			if err != nil {
+				continue
+			}
+			qtype := binary.BigEndian.Uint16(query[off:])
+
+			// Copy the header and question, and set the response flag
+			resp := append([]byte{}, query[:off+4]...)
+			resp[2] |= 0x80
+			records, ok := zone[name+" "+types[qtype]]
+			if !ok {
+				exists := false
+				for key := range zone {
+					exists = exists || strings.HasPrefix(key, name+" ")
+				}
+				if !exists {
+					resp[3] = 3 // NXDOMAIN
+				}
+			}
+			binary.BigEndian.PutUint16(resp[6:], uint16(len(records)))
+			for _, r := range records {
+				resp = append(resp, 0xc0, 12) // Pointer to the name of the question
+				resp = append(resp, byte(r.Type>>8), byte(r.Type), 0, 1, 0, 0, 0, 60)
+				resp = append(resp, byte(len(r.RData)>>8), byte(len(r.RData)))
+				resp = append(resp, r.RData...)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// encodeName is a helper function that encodes an uncompressed domain name.
+func encodeName(name string) []byte {
+	msg := BuildDNSQuery(0, name, 0)
+	return msg[12 : len(msg)-4]
+}
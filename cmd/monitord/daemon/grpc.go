@@ -0,0 +1,248 @@
+/*
+This file contains the gRPC health check logic, namely:
+- how gRPC check URLs are parsed
+- how the grpc.health.v1.Health/Check method is called over HTTP/2
+- how the serving status of the service is decoded
+*/
+
+package daemon
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterChecker("grpc", NewGRPCChecker)
+}
+
+// GRPCHealthPath is the path of the standard gRPC health checking method.
+const GRPCHealthPath = "/grpc.health.v1.Health/Check"
+
+// GRPCServingStatuses maps from the serving statuses of the health checking protocol to their name.
+var GRPCServingStatuses = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+// GRPCCodes maps from gRPC status codes to their name, for error messages.
+var GRPCCodes = map[int]string{
+	1:  "CANCELLED",
+	2:  "UNKNOWN",
+	3:  "INVALID_ARGUMENT",
+	4:  "DEADLINE_EXCEEDED",
+	5:  "NOT_FOUND",
+	7:  "PERMISSION_DENIED",
+	8:  "RESOURCE_EXHAUSTED",
+	12: "UNIMPLEMENTED",
+	13: "INTERNAL",
+	14: "UNAVAILABLE",
+	16: "UNAUTHENTICATED",
+}
+
+// GRPCChecker calls the standard health checking method of a gRPC server.
+type GRPCChecker struct {
+	URL       string            // URL of the health checking method, e.g. "http://host:port/grpc.health.v1.Health/Check"
+	Service   string            // Name of the checked service. If empty, the health of the whole server is checked
+	Metadata  map[string]string // Metadata sent with each call
+	Auth      Authenticator     // Adds credentials to the calls. If nil, calls are not authenticated
+	TLSConfig *tls.Config       // TLS settings of the calls. If nil, the default settings are used
+	Plaintext bool              // Whether calls are made over unencrypted HTTP/2
+	Timeouts  Timeouts          // Timeouts of the calls
+}
+
+// NewGRPCChecker creates a GRPCChecker from the configuration of a website.
+//
+// The URL is of the form grpc://host:port[/service] for plaintext connections,
+// or grpcs://host:port[/service] for TLS connections.
+func NewGRPCChecker(c WebsiteConfig) (Checker, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "grpc" && u.Scheme != "grpcs") || u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("gRPC checks require a grpc://host:port or grpcs://host:port URL, got %v", c.URL)
+	}
+
+	g := &GRPCChecker{
+		URL:       "https://" + u.Host + GRPCHealthPath,
+		Service:   strings.Trim(u.Path, "/"),
+		Metadata:  c.Headers,
+		Plaintext: u.Scheme == "grpc",
+		Timeouts:  NewTimeouts(c.Timeouts),
+	}
+	if g.Plaintext {
+		if c.TLS != (TLSConfig{}) {
+			return nil, errors.New("TLS settings require a grpcs:// URL")
+		}
+		g.URL = "http://" + u.Host + GRPCHealthPath
+	}
+	if g.Auth, err = NewAuthenticator(c.Auth); err != nil {
+		return nil, err
+	}
+	if g.TLSConfig, err = NewTLSConfig(c.TLS); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Check calls the health checking method of the server, measuring
+// the same times as HTTP requests. The poll result is valid
+// only if the service is reported as SERVING.
+func (g *GRPCChecker) Check() PollResult {
+	req, err := g.NewRequest()
+	if err == nil && g.Auth != nil {
+		err = g.Auth.Authenticate(req)
+	}
+	if err != nil {
+		return PollResult{Date: time.Now(), Error: err}
+	}
+
+	p, resp, body := TimedRoundTrip(req, g.NewTransport(), g.TLSConfig, g.Timeouts)
+	if p.Error != nil {
+		return p
+	}
+	if resp.StatusCode != http.StatusOK {
+		p.Error = fmt.Errorf("gRPC server replied with HTTP response code %v", resp.StatusCode)
+		return p
+	}
+
+	// The status of the call is sent in the trailers,
+	// or in the headers if the response has no body
+	status := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+		message = resp.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		p.Error = errors.New("gRPC response has no valid grpc-status")
+		return p
+	}
+	if code != 0 {
+		name, ok := GRPCCodes[code]
+		if !ok {
+			name = "code " + status
+		}
+		p.Error = fmt.Errorf("gRPC call failed with %v: %v", name, message)
+		return p
+	}
+
+	serving, err := ParseHealthResponse(body)
+	if err != nil {
+		p.Error = err
+	} else if serving != 1 {
+		name, ok := GRPCServingStatuses[serving]
+		if !ok {
+			name = strconv.FormatUint(serving, 10)
+		}
+		p.Error = fmt.Errorf("health check replied %v", name)
+	}
+	return p
+}
+
+// NewRequest creates the HTTP/2 request calling the health checking method of the server.
+func (g *GRPCChecker) NewRequest() (*http.Request, error) {
+	req, err := http.NewRequest("POST", g.URL, bytes.NewReader(BuildHealthRequest(g.Service)))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range g.Metadata {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	return req, nil
+}
+
+// NewTransport creates the transport used to call the server, which only speaks HTTP/2.
+func (g *GRPCChecker) NewTransport() *http.Transport {
+	t := NewTransport(g.TLSConfig, g.Timeouts)
+	t.Protocols = new(http.Protocols)
+	if g.Plaintext {
+		t.Protocols.SetUnencryptedHTTP2(true)
+	} else {
+		t.Protocols.SetHTTP2(true)
+	}
+	return t
+}
+
+// BuildHealthRequest encodes a grpc.health.v1.HealthCheckRequest message for the service,
+// prefixed as required by the gRPC protocol (uncompressed flag and message length).
+func BuildHealthRequest(service string) []byte {
+	var msg []byte
+	if service != "" {
+		// Field 1 (service), of type string
+		msg = append(msg, 0x0a)
+		msg = binary.AppendUvarint(msg, uint64(len(service)))
+		msg = append(msg, service...)
+	}
+	prefix := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	return append(prefix, msg...)
+}
+
+// ParseHealthResponse decodes a prefixed grpc.health.v1.HealthCheckResponse message,
+// and returns its serving status. Unknown fields are ignored.
+func ParseHealthResponse(body []byte) (status uint64, err error) {
+	if len(body) < 5 {
+		return 0, errors.New("gRPC response is too short")
+	}
+	if body[0] != 0 {
+		return 0, errors.New("gRPC response is compressed")
+	}
+	length := binary.BigEndian.Uint32(body[1:])
+	if uint64(len(body)-5) < uint64(length) {
+		return 0, errors.New("gRPC response is truncated")
+	}
+	msg := body[5 : 5+length]
+
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0, errors.New("invalid health check response")
+		}
+		msg = msg[n:]
+		switch key & 7 { // Wire type
+		case 0: // Varint
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0, errors.New("invalid health check response")
+			}
+			if key>>3 == 1 {
+				status = v
+			}
+			msg = msg[n:]
+		case 1: // 64-bit
+			if len(msg) < 8 {
+				return 0, errors.New("invalid health check response")
+			}
+			msg = msg[8:]
+		case 2: // Length-delimited
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return 0, errors.New("invalid health check response")
+			}
+			msg = msg[n+int(l):]
+		case 5: // 32-bit
+			if len(msg) < 4 {
+				return 0, errors.New("invalid health check response")
+			}
+			msg = msg[4:]
+		default:
+			return 0, errors.New("invalid health check response")
+		}
+	}
+	return
+}
@@ -0,0 +1,146 @@
+/*
+This file contains tests for the gRPC health check logic.
+*/
+
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test of gRPC health checks, over plaintext and TLS connections
+//
+// The server reports the status of a few services, and requires
+// an "x-api-key" metadata, as well as HTTP/2.
+func TestGRPCCheck(t *testing.T) {
+	statuses := map[string]byte{"": 1, "orders": 1, "payments": 2}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != GRPCHealthPath || r.Header.Get("Content-Type") != "application/grpc" {
+			t.Errorf("Unexpected request: %v %v %v", r.Proto, r.URL.Path, r.Header)
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		body, _ := ioutil.ReadAll(r.Body)
+		service := string(body[5:])
+		if service != "" {
+			service = service[2:] // Skip the field key and length
+		}
+
+		if r.Header.Get("X-Api-Key") != "secret" {
+			// Trailers-only response
+			w.Header().Set("Grpc-Status", "16")
+			w.Header().Set("Grpc-Message", "missing API key")
+			return
+		}
+		status, ok := statuses[service]
+		if !ok {
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "5")
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", "unknown service")
+			return
+		}
+		w.Write([]byte{0, 0, 0, 0, 2, 0x08, status})
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+
+	plaintext := httptest.NewUnstartedServer(handler)
+	plaintext.Config.Protocols = new(http.Protocols)
+	plaintext.Config.Protocols.SetUnencryptedHTTP2(true)
+	plaintext.Start()
+	defer plaintext.Close()
+
+	secure := httptest.NewUnstartedServer(handler)
+	secure.EnableHTTP2 = true
+	secure.StartTLS()
+	defer secure.Close()
+
+	plainURL := "grpc://" + strings.TrimPrefix(plaintext.URL, "http://")
+	secureURL := "grpcs://" + strings.TrimPrefix(secure.URL, "https://")
+	key := map[string]string{"x-api-key": "secret"}
+
+	// Create table of test cases
+	testCases := []struct {
+		config   WebsiteConfig
+		expected string // Expected error, or "" if the check should succeed
+	}{
+		{WebsiteConfig{URL: plainURL, Headers: key}, ""},
+		{WebsiteConfig{URL: plainURL + "/orders", Headers: key}, ""},
+		{WebsiteConfig{URL: plainURL + "/payments", Headers: key}, "health check replied NOT_SERVING"},
+		{WebsiteConfig{URL: plainURL + "/unknown", Headers: key}, "gRPC call failed with NOT_FOUND: unknown service"},
+		{WebsiteConfig{URL: plainURL}, "gRPC call failed with UNAUTHENTICATED: missing API key"},
+		{WebsiteConfig{URL: secureURL + "/orders", Headers: key, TLS: TLSConfig{InsecureSkipVerify: true}}, ""},
+		{WebsiteConfig{URL: secureURL + "/payments", Headers: key, TLS: TLSConfig{InsecureSkipVerify: true}}, "health check replied NOT_SERVING"},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			tc.config.Type = "grpc"
+			c, err := NewChecker(tc.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := c.Check()
+			computed := ""
+			if p.Error != nil {
+				computed = p.Error.Error()
+			}
+			if computed != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, computed)
+			}
+			if p.Timing.Response == 0 || p.Timing.Response < p.Timing.TTFB {
+				t.Errorf("Inconsistent timings: %+v", p.Timing)
+			}
+		})
+	}
+}
+
+// Test of invalid gRPC check URLs, which should be rejected on startup
+func TestInvalidGRPCConfig(t *testing.T) {
+	for _, c := range []WebsiteConfig{
+		{URL: "http://localhost:50051"},
+		{URL: "grpc://localhost"},
+		{URL: "grpc://localhost:50051", TLS: TLSConfig{InsecureSkipVerify: true}},
+	} {
+		if _, err := NewGRPCChecker(c); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
+
+// Test of the decoding of health check responses
+func TestParseHealthResponse(t *testing.T) {
+	// Create table of test cases
+	testCases := []struct {
+		body     []byte
+		expected uint64 // Expected serving status, or 99 if an error is expected
+	}{
+		{[]byte{0, 0, 0, 0, 2, 0x08, 1}, 1},
+		{[]byte{0, 0, 0, 0, 0}, 0},                        // Default value (UNKNOWN) is omitted
+		{[]byte{0, 0, 0, 0, 5, 0x12, 1, 'x', 0x08, 2}, 2}, // Unknown field is skipped
+		{[]byte{0, 0, 0, 0, 3, 0x08, 1}, 99},              // Truncated
+		{[]byte{1, 0, 0, 0, 2, 0x08, 1}, 99},              // Compressed
+		{[]byte{0, 0, 0, 0, 2, 0x08, 0x80}, 99},           // Invalid varint
+		{[]byte{0, 0, 0, 0, 3, 0x08, 0x80, 0x01}, 128},    // Multi-byte varint
+		{[]byte{0, 0, 0, 0, 4, 0x0a, 5, 'a', 'b'}, 99},    // Length-delimited field too long
+		{[]byte{0, 0, 0, 0, 2, 0x08, 3}, 3},               // SERVICE_UNKNOWN
+		{[]byte{0, 0}, 99},                                // Too short
+		{[]byte{0, 0, 0, 0, 2, 0x0b, 0}, 99},              // Unsupported wire type
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			computed, err := ParseHealthResponse(tc.body)
+			if err != nil {
+				computed = 99
+			}
+			if computed != tc.expected {
+				t.Errorf("Expected %v, got %v (%v)", tc.expected, computed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+/*
+This file contains the HTTP check logic, namely:
+- how the request sent to a website is built
+- how metrics are collected throughout the lifecycle of an HTTP request
+*/
+
+package daemon
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+func init() {
+	RegisterChecker("http", NewHTTPChecker)
+}
+
+// HTTPChecker polls a website over HTTP(S).
+type HTTPChecker struct {
+	URL        string
+	Method     string            // HTTP method of the requests
+	Headers    map[string]string // Headers of the requests
+	Body       []byte            // Body of the requests
+	Auth       Authenticator     // Adds credentials to the requests. If nil, requests are not authenticated
+	TLSConfig  *tls.Config       // TLS settings of the requests. If nil, the default settings are used
+	Assertions []Assertion       // Conditions that response bodies must satisfy
+	Redirects  RedirectPolicy    // How redirect responses are handled
+	Timeouts   Timeouts          // Timeouts of the requests
+}
+
+// NewHTTPChecker creates an HTTPChecker from the configuration of a website.
+func NewHTTPChecker(c WebsiteConfig) (Checker, error) {
+	h := &HTTPChecker{URL: c.URL, Method: c.Method, Headers: c.Headers, Timeouts: NewTimeouts(c.Timeouts)}
+	if h.Method == "" {
+		h.Method = "GET"
+	}
+
+	var err error
+	if h.Body, err = ReadBody(c); err != nil {
+		return nil, err
+	}
+	if h.Auth, err = NewAuthenticator(c.Auth); err != nil {
+		return nil, err
+	}
+	if h.TLSConfig, err = NewTLSConfig(c.TLS); err != nil {
+		return nil, err
+	}
+	if h.Assertions, err = NewAssertions(c.Assertions); err != nil {
+		return nil, err
+	}
+	if h.Redirects, err = NewRedirectPolicy(c.Redirects); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Check makes a request to the website, measuring various times
+// throughout the HTTP request, reading the HTTP response code,
+// following redirects according to the redirect policy,
+// and checking the final response body against the assertions.
+func (h *HTTPChecker) Check() PollResult {
+	// Create request
+	req, err := h.NewRequest()
+	if err == nil && h.Auth != nil {
+		// Credentials are added before tracing starts, so that
+		// fetching an OAuth2 token is not counted in the response time
+		err = h.Auth.Authenticate(req)
+	}
+	if err != nil {
+		return PollResult{Date: time.Now(), Error: err}
+	}
+
+	p, body := h.FollowRedirects(req, NewTransport(h.TLSConfig, h.Timeouts))
+	if p.Error == nil {
+		p.Error = CheckBody(h.Assertions, body)
+	}
+	return p
+}
+
+// TimedRoundTrip executes a request, measuring various times throughout the request,
+// and reading the response body. It also records the certificate chain presented
+// by the server, verified against the server name of tlsConfig if it is set.
+//
+// The connect, TLS and header timeouts are enforced by the transport,
+// while the overall and body timeouts are enforced by cancelling the request.
+// Timeout errors are reported as a TimeoutError.
+//
+// If the request fails, the error is stored in the poll result, and resp is nil.
+func TimedRoundTrip(req *http.Request, rt http.RoundTripper, tlsConfig *tls.Config, timeouts Timeouts) (p PollResult, resp *http.Response, body []byte) {
+	x := NewExpiry(req.Context())
+	defer x.Cancel()
+	defer x.Start(TimeoutOverall, timeouts.Overall)()
+
+	// Execute request and read response
+	var trace RequestTrace
+	resp, err := rt.RoundTrip(trace.Start(req.WithContext(x.Context), tlsConfig))
+	if err == nil {
+		p.StatusCode = resp.StatusCode
+		stop := x.Start(TimeoutBody, timeouts.Body)
+		body, err = ioutil.ReadAll(resp.Body)
+		stop()
+		resp.Body.Close()
+		trace.Done() // records the fact that the body has been read (response is over)
+	}
+	if e := x.Err(); err != nil && e != nil {
+		p.Error = e
+	} else {
+		p.Error = trace.Classify(err, timeouts)
+	}
+	trace.Save(&p)
+	return
+}
+
+// A RequestTrace records the exact times when the different parts
+// of an HTTP request are reached, as well as the certificate chain
+// presented by the server.
+type RequestTrace struct {
+	t         [7]time.Time // t stores those times
+	cert      *payload.Certificate
+	connected bool // Whether the TCP connection was established
+	gotConn   bool // Whether the connection was ready to send the request (i.e. after the TLS handshake)
+}
+
+// Start returns a copy of the request which is traced by r.
+// The certificate chain is verified against the server name of tlsConfig if it is set.
+//
+// The request starts now, or when the DNS lookup starts if there is one
+// (there is none when the URL contains an IP address).
+func (r *RequestTrace) Start(req *http.Request, tlsConfig *tls.Config) *http.Request {
+	r.t[0] = time.Now()
+	host := req.URL.Hostname()
+	if tlsConfig != nil && tlsConfig.ServerName != "" {
+		host = tlsConfig.ServerName
+	}
+	t := &r.t
+	trace := &httptrace.ClientTrace{
+		DNSStart:     func(_ httptrace.DNSStartInfo) { t[0] = time.Now() },
+		DNSDone:      func(_ httptrace.DNSDoneInfo) { t[1] = time.Now() },
+		ConnectStart: func(_, _ string) { t[2] = time.Now() },
+		ConnectDone: func(_, _ string, err error) {
+			t[3] = time.Now()
+			r.connected = err == nil
+		},
+		GotConn: func(_ httptrace.GotConnInfo) {
+			t[4] = time.Now()
+			r.gotConn = true
+		},
+		GotFirstResponseByte: func() { t[5] = time.Now() },
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			if err == nil {
+				r.cert = NewCertificate(cs, host)
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// Done records the end of the response.
+func (r *RequestTrace) Done() {
+	r.t[6] = time.Now()
+}
+
+// Save stores the recorded times and certificate in the poll result.
+func (r *RequestTrace) Save(p *PollResult) {
+	t := r.t
+
+	// If an error occured, some times of the t slice may still be at 0.
+	// However, they must be set to a sensible time to avoid getting
+	// absurd results when converting those times to meaningful durations.
+	for i := range t {
+		if (i > 0) && t[i].IsZero() {
+			t[i] = t[i-1]
+		}
+	}
+
+	p.Date = t[0]
+	p.Certificate = r.cert
+
+	// Convert the recorded times to meaningful durations
+	p.Timing = payload.Timing{
+		DNS:      t[1].Sub(t[0]),
+		TCP:      t[3].Sub(t[2]),
+		TLS:      t[4].Sub(t[3]),
+		Server:   t[5].Sub(t[4]),
+		Transfer: t[6].Sub(t[5]),
+		TTFB:     t[5].Sub(t[0]),
+		Response: t[6].Sub(t[0]),
+	}
+}
+
+// NewRequest creates the request sent to the website at each poll,
+// using the method, headers and body defined in the config file.
+func (h *HTTPChecker) NewRequest() (*http.Request, error) {
+	return h.newRequest(h.Method, h.URL, h.Body)
+}
+
+// newRequest creates a request with the headers defined in the config file.
+func (h *HTTPChecker) newRequest(method, url string, b []byte) (*http.Request, error) {
+	var body io.Reader
+	if len(b) != 0 {
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h.Headers {
+		if http.CanonicalHeaderKey(k) == "Host" {
+			// The Host header is ignored by the transport, and must be set on the request instead
+			req.Host = v
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+	return req, nil
+}
+
+// NewTransport creates a new http.Transport, using the provided TLS settings
+// (or the default ones if tlsConfig is nil), and the connect, TLS and header timeouts.
+//
+// Keep-alive is disabled, to ensure that processes such as DNS lookup
+// and TLS handshakes are tested at each request.
+func NewTransport(tlsConfig *tls.Config, timeouts Timeouts) *http.Transport {
+	return &http.Transport{
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: true,
+		DialContext: (&net.Dialer{
+			Timeout:   timeouts.Connect,
+			KeepAlive: 4 * time.Second,
+			DualStack: true,
+		}).DialContext,
+		IdleConnTimeout:       4 * time.Second,
+		TLSHandshakeTimeout:   timeouts.TLS,
+		ResponseHeaderTimeout: timeouts.Header,
+	}
+}
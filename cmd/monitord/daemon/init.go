@@ -2,13 +2,20 @@
 This file contains the main data types used by the daemon,
 and the init logic used on daemon startup:
 - to create Website objects from URLs
-- to launch websites' poll schedulers
+- to launch websites' poll schedulers and retention jobs
 */
 
 package daemon
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -22,22 +29,54 @@ type Websites []Website
 // as well as all the corresponding poll results.
 type Website struct {
 	URL             string
-	Interval        int     // Interval, in seconds, between two polls
-	RetainedResults int     // Number of poll results that should be kept. If set to 0, no poll result is ever deleted
-	Threshold       float64 // Availability threshold that should trigger an alert when crossed
-	PollResults     *PollResults
+	Checker         Checker       // Probes the website, according to its type of check
+	Interval        int           // Interval, in seconds, between two polls
+	RetainedResults int           // Number of poll results that should be kept. If set to 0, no poll result is ever deleted
+	Threshold       float64       // Availability threshold that should trigger an alert when crossed
+	CertificateDays int           // Number of days before certificate expiry under which an alert is raised
+	Webhooks        []string      // Endpoints to which alerts are posted
+	Retries         int           // Number of times a failed poll is retried before its failure is recorded
+	RetryDelay      time.Duration // Delay between two attempts of a poll
+	Warnings        []string      // Configuration warnings shown on the dashboard
+	PollResults     ResultStore
+	RawRetention    time.Duration   // Duration during which poll results are kept. If 0, poll results never expire
+	Rollups         []*RollupSeries // Summaries of poll results, sorted by increasing resolution
+	Buckets         []time.Duration // Upper bounds of the buckets of the response time distribution
+	Policy          Policy          // Additional criteria that poll results must satisfy to be valid
 
-	// DownAlertSent is true if at the last alert check from the front-end,
+	// DownAlertSent is true if at the last alert check by the AlertEngine,
 	// the aggregate availability was below the threshold. Keeping this information:
 	// - avoids sending repetitive "website is down!" alerts
 	// - enables the sending of one "website is up!" alert upon website recovery
 	DownAlertSent bool
+
+	// CertAlertSent is true if at the last certificate check by the AlertEngine,
+	// the certificate of the website was about to expire.
+	CertAlertSent bool
+}
+
+// A ResultStore stores the poll results of a website.
+//
+// PollResults keeps poll results in memory only, while SegmentStore
+// also persists them on disk, so that they survive daemon restarts.
+type ResultStore interface {
+	// Save saves a poll result at the end of the store.
+	// If retained is not 0, only the latest retained poll results are kept.
+	Save(p PollResult, retained int) error
+
+	// Extract returns the poll results that are included in the provided timeframe,
+	// sorted by increasing date.
+	Extract(tf payload.Timeframe) []PollResult
+
+	// Expire deletes the poll results that are older than the provided date.
+	Expire(before time.Time) error
 }
 
 // PollResults represents all the trace results for a given website.
 //
 // The retention policy of those results is user-defined: in the config file,
-// the RetainedResults parameter specifies how many poll results to keep.
+// the RetainedResults parameter specifies how many poll results to keep,
+// and the Retention.Raw parameter specifies for how long they are kept.
 type PollResults struct {
 	sync.RWMutex
 	items []PollResult
@@ -51,18 +90,81 @@ type PollResult struct {
 	// Timing contains the duration of the different phases of the request.
 	Timing payload.Timing
 
-	// Error stores the error if the request resulted in a client error, or nil otherwise.
+	// Error stores the error if the request resulted in a client error, or if the
+	// response body failed an assertion (in which case StatusCode is also set), or nil otherwise.
 	Error error
 
 	// StatusCode stores the HTTP response code of the request, or 0 if the request
 	// resulted in a (non-HTTP) client error.
 	StatusCode int
+
+	// Violation describes how the response violated the website's policy,
+	// or is empty if the response complied with it.
+	Violation string
+
+	// Certificate describes the TLS certificate chain presented by the website,
+	// or is nil if the request did not use TLS or if the handshake failed.
+	Certificate *payload.Certificate
+
+	// Attempts is the number of times the website was checked during the poll:
+	// failed checks are retried up to Website.Retries times.
+	// A valid poll result with more than one attempt hints at a flaky network
+	// rather than an outage. It is 0 for poll results recorded before retries were introduced.
+	Attempts int
+
+	// Steps contains the result of each step of journey checks, up to the first failing one.
+	// It is empty for other types of check.
+	Steps []StepResult `json:",omitempty"`
+
+	// Hops contains the redirect chain followed by HTTP checks, including the final response.
+	// It is empty if no redirect was followed.
+	Hops []payload.Hop `json:",omitempty"`
+}
+
+// A StepResult represents the results of one step of a journey check.
+type StepResult struct {
+	Name       string
+	StatusCode int
+	Timing     payload.Timing
+}
+
+// MarshalJSON encodes the poll result in JSON.
+// As the error interface cannot be decoded, Error is encoded as a string.
+func (p PollResult) MarshalJSON() ([]byte, error) {
+	type alias PollResult // alias does not have the MarshalJSON method
+	r := struct {
+		alias
+		Error string
+	}{alias: alias(p)}
+	if p.Error != nil {
+		r.Error = p.Error.Error()
+	}
+	return json.Marshal(r)
+}
+
+// UnmarshalJSON decodes a poll result encoded with MarshalJSON.
+func (p *PollResult) UnmarshalJSON(data []byte) error {
+	type alias PollResult // alias does not have the UnmarshalJSON method
+	var r struct {
+		alias
+		Error string
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	*p = PollResult(r.alias)
+	if r.Error != "" {
+		p.Error = errors.New(r.Error)
+	}
+	return nil
 }
 
 // NewWebsites creates a new Websites object from a slice of URLs.
 //
 // NB: different URLs of the same domain (purposefully) lead
 // to the creation of multiple Website objects.
+//
+// The program exits if the persisted poll results of a website cannot be loaded.
 func NewWebsites(c *Config) (w Websites) {
 	for _, website := range c.Websites {
 		// Create website object
@@ -71,7 +173,11 @@ func NewWebsites(c *Config) (w Websites) {
 			Interval:        website.Interval,
 			RetainedResults: website.RetainedResults,
 			Threshold:       website.Threshold,
-			PollResults:     &PollResults{},
+			CertificateDays: website.CertificateDays,
+			Webhooks:        website.Webhooks,
+			Retries:         website.Retries,
+			RetryDelay:      time.Duration(website.RetryDelay) * time.Millisecond,
+			Policy:          NewPolicy(website.Policy, c.Default.Policy),
 		}
 
 		// Fallback to defaults if website-specific attributes not used
@@ -84,16 +190,88 @@ func NewWebsites(c *Config) (w Websites) {
 		if currW.Threshold == 0 {
 			currW.Threshold = c.Default.Threshold
 		}
+		if currW.CertificateDays == 0 {
+			currW.CertificateDays = c.Default.CertificateDays
+		}
+		if currW.Webhooks == nil {
+			currW.Webhooks = c.Default.Webhooks
+		}
+		if currW.Retries == 0 {
+			currW.Retries = c.Default.Retries
+		}
+		if currW.RetryDelay == 0 {
+			currW.RetryDelay = time.Duration(c.Default.RetryDelay) * time.Millisecond
+		}
+
+		// Create the checker, with the default headers overridden by the website's ones
+		headers := make(map[string]string)
+		for k, v := range c.Default.Headers {
+			headers[k] = v
+		}
+		for k, v := range website.Headers {
+			headers[k] = v
+		}
+		website.Headers = headers
+		website.Timeouts = website.Timeouts.WithDefaults(c.Default.Timeouts)
+		checker, err := NewChecker(website)
+		if err != nil {
+			log.Fatal(website.URL, ": ", err)
+		}
+		currW.Checker = checker
+		currW.Warnings = TLSWarnings(website.TLS)
+
+		// Create the store of poll results
+		dir := ""
+		if c.Storage.Path == "" {
+			currW.PollResults = &PollResults{}
+		} else {
+			dir = filepath.Join(c.Storage.Path, url.PathEscape(currW.URL))
+			store, err := OpenSegmentStore(dir, c.Storage.SegmentSize, currW.RetainedResults)
+			if err != nil {
+				log.Fatal(err)
+			}
+			currW.PollResults = store
+		}
+
+		// Sort the upper bounds of the response time distribution
+		for _, ms := range c.Histogram {
+			currW.Buckets = append(currW.Buckets, time.Duration(ms)*time.Millisecond)
+		}
+		sort.Slice(currW.Buckets, func(i, j int) bool { return currW.Buckets[i] < currW.Buckets[j] })
+
+		// Create the rollups, from the finest to the coarsest resolution
+		currW.RawRetention = time.Duration(c.Retention.Raw) * time.Second
+		rollups := append([]RollupConfig{}, c.Retention.Rollups...)
+		sort.Slice(rollups, func(i, j int) bool { return rollups[i].Resolution < rollups[j].Resolution })
+		for _, rc := range rollups {
+			path := ""
+			if dir != "" {
+				path = filepath.Join(dir, "rollups-"+strconv.Itoa(rc.Resolution)+"s.log")
+			}
+			series, err := OpenRollupSeries(rc, path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			series.Buckets = currW.Buckets
+			currW.Rollups = append(currW.Rollups, series)
+		}
+		if err := currW.CheckRetention(); err != nil {
+			log.Fatal(currW.URL, ": ", err)
+		}
 
 		w = append(w, currW)
 	}
 	return
 }
 
-// InitPolls launches, for each website, a poll scheduler in a separate goroutine.
+// InitPolls launches, for each website, a poll scheduler in a separate goroutine,
+// as well as a retention job if time-based retention is enabled.
 func (w Websites) InitPolls() {
 	for i := range w {
 		go w[i].SchedulePolls()
+		if w[i].RawRetention != 0 || len(w[i].Rollups) != 0 {
+			go w[i].ScheduleRetention()
+		}
 	}
 	fmt.Println("All checks launched.")
 }
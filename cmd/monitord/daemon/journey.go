@@ -0,0 +1,249 @@
+/*
+This file contains the journey check logic, namely:
+- how the steps of a journey are built from the config file
+- how variables are extracted from responses and substituted in later requests
+- how the results of the steps are combined into a single poll result
+*/
+
+package daemon
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterChecker("journey", NewJourneyChecker)
+}
+
+// JourneyChecker runs a sequence of HTTP requests, such as a login flow.
+type JourneyChecker struct {
+	URL       *url.URL          // Base URL of the steps
+	Headers   map[string]string // Headers of all the requests
+	Steps     []Step
+	Auth      Authenticator // Adds credentials to the requests. If nil, requests are not authenticated
+	TLSConfig *tls.Config   // TLS settings of the requests. If nil, the default settings are used
+	Timeouts  Timeouts      // Timeouts of each request
+}
+
+// A Step is a request of a journey check.
+// Its URL, headers and body may contain variables, written as {{name}}.
+type Step struct {
+	Name       string
+	URL        string
+	Method     string
+	Headers    map[string]string
+	Body       string
+	Assertions []Assertion
+	Extract    map[string]Extractor // Variables extracted from the response, by name
+}
+
+// An Extractor extracts the value of a variable from a response.
+type Extractor struct {
+	Config ExtractConfig
+	path   []interface{} // Parsed JSONPath
+}
+
+// NewJourneyChecker creates a JourneyChecker from the configuration of a website.
+func NewJourneyChecker(c WebsiteConfig) (Checker, error) {
+	if len(c.Steps) == 0 {
+		return nil, errors.New("journey checks require at least one step")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &JourneyChecker{URL: u, Headers: c.Headers, Timeouts: NewTimeouts(c.Timeouts)}
+	if j.Auth, err = NewAuthenticator(c.Auth); err != nil {
+		return nil, err
+	}
+	if j.TLSConfig, err = NewTLSConfig(c.TLS); err != nil {
+		return nil, err
+	}
+	for i, sc := range c.Steps {
+		s := Step{
+			Name:    sc.Name,
+			URL:     sc.URL,
+			Method:  sc.Method,
+			Headers: sc.Headers,
+			Body:    sc.Body,
+			Extract: make(map[string]Extractor),
+		}
+		if s.Name == "" {
+			s.Name = "step " + strconv.Itoa(i+1)
+		}
+		if s.Method == "" {
+			s.Method = "GET"
+		}
+		if s.Assertions, err = NewAssertions(sc.Assertions); err != nil {
+			return nil, fmt.Errorf("%v: %v", s.Name, err)
+		}
+		for name, ec := range sc.Extract {
+			e, err := NewExtractor(ec)
+			if err != nil {
+				return nil, fmt.Errorf("%v: variable %v: %v", s.Name, name, err)
+			}
+			s.Extract[name] = e
+		}
+		j.Steps = append(j.Steps, s)
+	}
+	return j, nil
+}
+
+// NewExtractor checks that exactly one source is set, and parses its JSONPath if any.
+func NewExtractor(c ExtractConfig) (e Extractor, err error) {
+	e.Config = c
+	n := 0
+	for _, source := range []string{c.Header, c.Cookie, c.JSONPath} {
+		if source != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return e, errors.New("exactly one of Header, Cookie or JSONPath must be set")
+	}
+	if c.JSONPath != "" {
+		e.path, err = ParseJSONPath(c.JSONPath)
+	}
+	return
+}
+
+// Check runs the steps in order, stopping at the first failing one.
+//
+// The timings of the poll result are the sums of the timings of the steps,
+// and its status code is the one of the last step that was run.
+// A step fails if its request fails, if its response code is 400 or above,
+// if its body fails an assertion, or if a variable cannot be extracted.
+func (j *JourneyChecker) Check() (p PollResult) {
+	jar, _ := cookiejar.New(nil) // Cookies are only kept for the duration of the journey
+	vars := make(map[string]string)
+	transport := NewTransport(j.TLSConfig, j.Timeouts)
+
+	for i, s := range j.Steps {
+		sp, err := j.RunStep(s, vars, jar, transport)
+		if i == 0 {
+			p.Date = sp.Date
+		}
+		if p.Certificate == nil {
+			p.Certificate = sp.Certificate
+		}
+		p.StatusCode = sp.StatusCode
+		p.Timing = AddTiming(p.Timing, sp.Timing)
+		p.Steps = append(p.Steps, StepResult{Name: s.Name, StatusCode: sp.StatusCode, Timing: sp.Timing})
+		if err != nil {
+			p.Error = fmt.Errorf("%v: %v", s.Name, err)
+			return
+		}
+	}
+	return
+}
+
+// RunStep makes the request of a step, checks its response, and extracts its variables into vars.
+func (j *JourneyChecker) RunStep(s Step, vars map[string]string, jar http.CookieJar, rt http.RoundTripper) (PollResult, error) {
+	req, err := j.NewRequest(s, vars)
+	if err == nil && j.Auth != nil {
+		err = j.Auth.Authenticate(req)
+	}
+	if err != nil {
+		return PollResult{Date: time.Now()}, err
+	}
+	for _, c := range jar.Cookies(req.URL) {
+		req.AddCookie(c)
+	}
+
+	p, resp, body := TimedRoundTrip(req, rt, j.TLSConfig, j.Timeouts)
+	if p.Error != nil {
+		return p, p.Error
+	}
+	jar.SetCookies(req.URL, resp.Cookies())
+	if resp.StatusCode >= 400 {
+		return p, fmt.Errorf("response code %v", resp.StatusCode)
+	}
+	if err = CheckBody(s.Assertions, body); err != nil {
+		return p, err
+	}
+	for name, e := range s.Extract {
+		if vars[name], err = e.Extract(resp, body); err != nil {
+			return p, fmt.Errorf("variable %v: %v", name, err)
+		}
+	}
+	return p, nil
+}
+
+// NewRequest creates the request of a step, substituting the variables
+// in its URL, headers and body.
+func (j *JourneyChecker) NewRequest(s Step, vars map[string]string) (*http.Request, error) {
+	pairs := make([]string, 0, 2*len(vars))
+	for name, value := range vars {
+		pairs = append(pairs, "{{"+name+"}}", value)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	u, err := j.URL.Parse(replacer.Replace(s.URL))
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	if s.Body != "" {
+		body = bytes.NewReader([]byte(replacer.Replace(s.Body)))
+	}
+	req, err := http.NewRequest(s.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for _, headers := range []map[string]string{j.Headers, s.Headers} {
+		for k, v := range headers {
+			v = replacer.Replace(v)
+			if http.CanonicalHeaderKey(k) == "Host" {
+				req.Host = v
+			} else {
+				req.Header.Set(k, v)
+			}
+		}
+	}
+	return req, nil
+}
+
+// Extract returns the value of a variable from a response and its body.
+// JSON fields that are not strings are returned in their JSON encoding.
+func (e Extractor) Extract(resp *http.Response, body []byte) (string, error) {
+	switch {
+	case e.Config.Header != "":
+		if v := resp.Header.Get(e.Config.Header); v != "" {
+			return v, nil
+		}
+		return "", fmt.Errorf("no %v header in response", e.Config.Header)
+	case e.Config.Cookie != "":
+		for _, c := range resp.Cookies() {
+			if c.Name == e.Config.Cookie {
+				return c.Value, nil
+			}
+		}
+		return "", fmt.Errorf("no %v cookie in response", e.Config.Cookie)
+	default:
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "", errors.New("body is not valid JSON")
+		}
+		v, ok := EvalJSONPath(doc, e.path)
+		if !ok {
+			return "", fmt.Errorf("body does not have %v", e.Config.JSONPath)
+		}
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
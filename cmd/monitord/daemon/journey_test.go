@@ -0,0 +1,122 @@
+/*
+This file contains tests for the journey check logic.
+*/
+
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test of journey checks
+//
+// The server simulates a login flow: POST /login sets a session cookie
+// and returns a token, which are both required by GET /account/42.
+func TestJourneyCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Method != "POST" || string(body) != `{"user": "alice"}` {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+			w.Header().Set("X-Request-Id", "r1")
+			w.Write([]byte(`{"token": "t1", "user": {"id": 42}}`))
+		case "/account/42":
+			c, err := r.Cookie("session")
+			if err != nil || c.Value != "abc" || r.Header.Get("Authorization") != "Bearer t1" || r.Header.Get("X-Request-Id") != "r1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"plan": "pro"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	login := StepConfig{
+		Name:   "login",
+		URL:    "/login",
+		Method: "POST",
+		Body:   `{"user": "alice"}`,
+		Extract: map[string]ExtractConfig{
+			"token":   {JSONPath: "$.token"},
+			"id":      {JSONPath: "$.user.id"},
+			"request": {Header: "X-Request-Id"},
+			"session": {Cookie: "session"},
+		},
+	}
+	account := StepConfig{
+		Name:       "account",
+		URL:        "/account/{{id}}",
+		Headers:    map[string]string{"Authorization": "Bearer {{token}}", "X-Request-Id": "{{request}}"},
+		Assertions: []AssertionConfig{{JSONPath: "$.plan", Equals: []byte(`"pro"`)}},
+	}
+	wrongPlan := account
+	wrongPlan.Assertions = []AssertionConfig{{Contains: "enterprise"}}
+	anonymous := account
+	anonymous.URL = "/account/42"
+	missingField := login
+	missingField.Extract = map[string]ExtractConfig{"name": {JSONPath: "$.user.name"}}
+
+	// Create table of test cases
+	testCases := []struct {
+		steps    []StepConfig
+		expected string // Expected error, or "" if the journey should succeed
+		results  int    // Expected number of step results
+	}{
+		{[]StepConfig{login, account}, "", 2},
+		{[]StepConfig{login, wrongPlan}, `account: body does not contain "enterprise"`, 2},
+		{[]StepConfig{missingField, account}, "login: variable name: body does not have $.user.name", 1},
+		{[]StepConfig{anonymous}, "account: response code 401", 1},
+		{[]StepConfig{{URL: "/unknown"}}, "step 1: response code 404", 1},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			c, err := NewChecker(WebsiteConfig{URL: server.URL, Type: "journey", Steps: tc.steps})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := c.Check()
+			computed := ""
+			if p.Error != nil {
+				computed = p.Error.Error()
+			}
+			if computed != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, computed)
+			}
+			if len(p.Steps) != tc.results {
+				t.Fatalf("Expected %v step results, got %+v", tc.results, p.Steps)
+			}
+			if sum := AddTiming(p.Steps[0].Timing, p.Steps[len(p.Steps)-1].Timing); len(p.Steps) == 2 && sum != p.Timing {
+				t.Errorf("Expected timings %+v, got %+v", sum, p.Timing)
+			}
+			if p.StatusCode != p.Steps[len(p.Steps)-1].StatusCode {
+				t.Errorf("Expected status code of the last step, got %v", p.StatusCode)
+			}
+		})
+	}
+}
+
+// Test of invalid journey configurations, which should be rejected on startup
+func TestInvalidJourneyConfig(t *testing.T) {
+	for _, c := range []WebsiteConfig{
+		{URL: "https://example.com"},
+		{URL: "https://example.com", Steps: []StepConfig{{Extract: map[string]ExtractConfig{"v": {}}}}},
+		{URL: "https://example.com", Steps: []StepConfig{{Extract: map[string]ExtractConfig{"v": {Header: "A", Cookie: "b"}}}}},
+		{URL: "https://example.com", Steps: []StepConfig{{Extract: map[string]ExtractConfig{"v": {JSONPath: "token"}}}}},
+	} {
+		if _, err := NewJourneyChecker(c); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
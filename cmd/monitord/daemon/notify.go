@@ -0,0 +1,437 @@
+/*
+This file contains the notification logic, namely:
+- how alerts are dispatched to notifiers
+- how alerts are posted to webhook endpoints
+- how alerts are sent by email
+- how alerts are passed to a local command
+*/
+
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// A Notifier informs website maintainers of new alerts,
+// e.g. by posting them to a chat or sending an email.
+type Notifier interface {
+	// Notify sends the alerts generated by one evaluation of the AlertEngine.
+	Notify(alerts payload.Alerts) error
+}
+
+// NewNotifiers creates the notifiers that are enabled in the config file.
+func NewNotifiers(w Websites, c *Config) (n []Notifier) {
+	if webhook := NewWebhookNotifier(w, c.Notifications.Webhook); len(webhook.Endpoints) != 0 {
+		n = append(n, webhook)
+	}
+	if c.Notifications.Email.Server != "" {
+		n = append(n, NewEmailNotifier(c.Notifications.Email))
+	}
+	if c.Notifications.Exec.Command != "" {
+		n = append(n, NewExecNotifier(c.Notifications.Exec))
+	}
+	return
+}
+
+// NotificationQueueSize is the number of evaluations whose alerts can wait
+// for a notifier before the evaluation of alerts is blocked.
+const NotificationQueueSize = 100
+
+// A NotificationQueue sends alerts to a notifier in a separate goroutine,
+// so that a slow notifier does not delay the evaluation of alerts.
+//
+// Alerts are sent one evaluation at a time, in the order in which they
+// were generated: a "website is up!" alert is never sent before the
+// "website is down!" alert that precedes it, even if the latter is retried.
+type NotificationQueue struct {
+	Notifier Notifier
+	alerts   chan payload.Alerts
+}
+
+// NewNotificationQueue creates a NotificationQueue, and launches
+// the goroutine that sends the queued alerts to the notifier.
+func NewNotificationQueue(n Notifier) *NotificationQueue {
+	q := &NotificationQueue{Notifier: n, alerts: make(chan payload.Alerts, NotificationQueueSize)}
+	go func() {
+		for alerts := range q.alerts {
+			if err := q.Notifier.Notify(alerts); err != nil {
+				fmt.Println("Could not send notification:", err)
+			}
+		}
+	}()
+	return q
+}
+
+// Notify queues the alerts of an evaluation on each notification queue.
+func Notify(queues []*NotificationQueue, alerts payload.Alerts) {
+	for _, q := range queues {
+		q.alerts <- alerts
+	}
+}
+
+// WebhookNotifier posts a JSON document to the webhook endpoints
+// of a website when an alert is generated for that website.
+type WebhookNotifier struct {
+	Endpoints map[string][]string // Maps from a website URL to its webhook endpoints
+	Client    *http.Client
+	Retries   int           // Number of retries when a request fails
+	Backoff   time.Duration // Delay before the first retry. It is doubled after each retry
+}
+
+// Webhook is the JSON document posted to webhook endpoints.
+type Webhook struct {
+	Type         string    `json:"type"` // "availability" or "certificate"
+	URL          string    `json:"url"`
+	Status       string    `json:"status"` // "down" or "up" for availability alerts, "expiring" or "renewed" for certificate alerts
+	Availability float64   `json:"availability"`
+	Threshold    float64   `json:"threshold"`
+	Date         time.Time `json:"date"`
+	Timeframe    struct {
+		Start   time.Time `json:"start"`
+		End     time.Time `json:"end"`
+		Seconds int       `json:"seconds"`
+	} `json:"timeframe"`
+	Certificate *WebhookCertificate `json:"certificate,omitempty"` // Only set for certificate alerts
+}
+
+// WebhookCertificate describes the certificate of a certificate alert in webhooks.
+type WebhookCertificate struct {
+	Subject         string    `json:"subject"`
+	Issuer          string    `json:"issuer"`
+	Expires         time.Time `json:"expires"` // Earliest expiry date of the certificate chain
+	DaysUntilExpiry int       `json:"days_until_expiry"`
+}
+
+// NewWebhookNotifier creates a new WebhookNotifier for the provided websites.
+func NewWebhookNotifier(w Websites, c WebhookConfig) *WebhookNotifier {
+	n := &WebhookNotifier{
+		Endpoints: make(map[string][]string),
+		Client:    &http.Client{Timeout: time.Duration(c.Timeout) * time.Second},
+		Retries:   c.Retries,
+		Backoff:   time.Duration(c.Backoff) * time.Second,
+	}
+	if c.Timeout == 0 {
+		n.Client.Timeout = 10 * time.Second
+	}
+	for _, website := range w {
+		if len(website.Webhooks) != 0 {
+			n.Endpoints[website.URL] = website.Webhooks
+		}
+	}
+	return n
+}
+
+// NewWebhook converts an alert to the JSON document posted to webhook endpoints.
+func NewWebhook(a payload.Alert) (h Webhook) {
+	h.Type = a.Type
+	h.URL = a.URL
+	h.Status = AlertStatus(a)
+	h.Availability = a.Availability
+	h.Threshold = a.Threshold
+	h.Date = a.Date
+	h.Timeframe.Start = a.Timeframe.StartDate
+	h.Timeframe.End = a.Timeframe.EndDate
+	h.Timeframe.Seconds = a.Timeframe.Seconds
+	if c := a.Certificate; c != nil {
+		h.Certificate = &WebhookCertificate{c.Subject, c.Issuer, c.ChainNotAfter, c.DaysUntilExpiry}
+	}
+	return
+}
+
+// AlertStatus returns the status described by the alert:
+// "down" or "up" for availability alerts, "expiring" or "renewed" for certificate alerts.
+func AlertStatus(a payload.Alert) string {
+	if a.Type == payload.CertificateAlert {
+		if a.BelowThreshold {
+			return "expiring"
+		}
+		return "renewed"
+	}
+	if a.BelowThreshold {
+		return "down"
+	}
+	return "up"
+}
+
+// Notify posts each alert to the webhook endpoints of the corresponding website.
+// Endpoints are called concurrently.
+//
+// It returns the last error encountered, if any.
+func (n *WebhookNotifier) Notify(alerts payload.Alerts) (err error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex // Protects err
+	for _, a := range alerts {
+		data, jsonErr := json.Marshal(NewWebhook(a))
+		if jsonErr != nil {
+			return jsonErr
+		}
+		for _, endpoint := range n.Endpoints[a.URL] {
+			wg.Add(1)
+			go func(endpoint string) {
+				defer wg.Done()
+				if postErr := n.Post(endpoint, data); postErr != nil {
+					mu.Lock()
+					err = postErr
+					mu.Unlock()
+				}
+			}(endpoint)
+		}
+	}
+	wg.Wait()
+	return
+}
+
+// Post sends the JSON document to the endpoint. If the request fails or if the
+// endpoint does not reply with a 2xx response code, the request is retried
+// with an exponential backoff, up to n.Retries times.
+func (n *WebhookNotifier) Post(endpoint string, data []byte) (err error) {
+	backoff := n.Backoff
+	for attempt := 0; attempt <= n.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var resp *http.Response
+		resp, err = n.Client.Post(endpoint, "application/json", bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("webhook %v replied with response code %v", endpoint, resp.StatusCode)
+	}
+	return
+}
+
+// EmailNotifier sends alerts by email, using an SMTP server.
+//
+// All the alerts generated by one evaluation of the AlertEngine
+// are batched into a single email.
+type EmailNotifier struct {
+	Config   EmailConfig
+	Template *template.Template // Template of the email body, executed with the alerts
+	Timeout  time.Duration      // Timeout of the connection to the SMTP server
+}
+
+// EmailTemplate is the template of the body of alert emails.
+const EmailTemplate = `{{range .}}{{if eq .Type "certificate"}}Certificate of website {{.URL}} {{if .BelowThreshold}}expires soon{{else}}was renewed{{end}}.
+	Expires on {{.Certificate.ChainNotAfter}} ({{.Certificate.DaysUntilExpiry}} days, threshold: {{.Threshold}} days)
+	Subject: {{.Certificate.Subject}}, issuer: {{.Certificate.Issuer}}
+{{else}}Website {{.URL}} is {{if .BelowThreshold}}down{{else}}up{{end}}.
+	Availability: {{printf "%.3f" .Availability}} (threshold: {{printf "%.3f" .Threshold}})
+	Computed from {{.Timeframe.StartDate}} to {{.Timeframe.EndDate}}
+{{end}}	Alert generated at {{.Date}}
+
+{{end}}`
+
+// NewEmailNotifier creates a new EmailNotifier.
+func NewEmailNotifier(c EmailConfig) *EmailNotifier {
+	return &EmailNotifier{
+		Config:   c,
+		Template: template.Must(template.New("email").Parse(EmailTemplate)),
+		Timeout:  10 * time.Second,
+	}
+}
+
+// Notify sends one email containing all the alerts to the recipients.
+func (n *EmailNotifier) Notify(alerts payload.Alerts) error {
+	msg, err := n.Message(alerts)
+	if err != nil {
+		return err
+	}
+
+	// Connect to the SMTP server
+	conn, err := net.DialTimeout("tcp", n.Config.Server, n.Timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(n.Timeout))
+	host, _, _ := net.SplitHostPort(n.Config.Server)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	// Secure the connection and authenticate, if required
+	if n.Config.StartTLS {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.Config.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", n.Config.Username, n.Config.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	// Send the email
+	if err = c.Mail(n.Config.From); err != nil {
+		return err
+	}
+	for _, r := range n.Config.Recipients {
+		if err = c.Rcpt(r); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Message returns the email (headers and body) describing the alerts.
+func (n *EmailNotifier) Message(alerts payload.Alerts) ([]byte, error) {
+	// Summarize the alerts in the subject
+	var summary []string
+	for _, a := range alerts {
+		if a.Type == payload.CertificateAlert {
+			summary = append(summary, a.URL+" certificate is "+AlertStatus(a))
+		} else {
+			summary = append(summary, a.URL+" is "+AlertStatus(a))
+		}
+	}
+
+	var msg bytes.Buffer
+	msg.WriteString("From: " + n.Config.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(n.Config.Recipients, ", ") + "\r\n")
+	msg.WriteString("Subject: [monitor] " + strings.Join(summary, ", ") + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	if err := n.Template.Execute(&msg, alerts); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// ExecNotifier runs a local command for each alert, e.g. to forward
+// alerts to a paging tool.
+//
+// The alert is passed to the command both as environment variables
+// (MONITOR_URL, MONITOR_STATUS, etc.) and as a JSON document on its
+// standard input, using the same format as webhooks.
+type ExecNotifier struct {
+	Config  ExecConfig
+	Timeout time.Duration // Duration after which the command is killed
+
+	// slots limits the number of commands running at the same time:
+	// a command may only start once it has put a value in the channel.
+	slots chan bool
+}
+
+// NewExecNotifier creates a new ExecNotifier.
+// The program exits if the timeout or the concurrency is negative.
+func NewExecNotifier(c ExecConfig) *ExecNotifier {
+	if c.Timeout < 0 || c.Concurrency < 0 {
+		log.Fatal("exec notifier: Timeout and Concurrency must not be negative")
+	}
+	n := &ExecNotifier{
+		Config:  c,
+		Timeout: time.Duration(c.Timeout) * time.Second,
+		slots:   make(chan bool, c.Concurrency),
+	}
+	if c.Timeout == 0 {
+		n.Timeout = 10 * time.Second
+	}
+	if c.Concurrency == 0 {
+		n.slots = make(chan bool, 1)
+	}
+	return n
+}
+
+// Notify runs the command once for each alert.
+//
+// It returns the last error encountered, if any.
+func (n *ExecNotifier) Notify(alerts payload.Alerts) (err error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex // Protects err
+	for _, a := range alerts {
+		wg.Add(1)
+		go func(a payload.Alert) {
+			defer wg.Done()
+			if runErr := n.Run(a); runErr != nil {
+				mu.Lock()
+				err = runErr
+				mu.Unlock()
+			}
+		}(a)
+	}
+	wg.Wait()
+	return
+}
+
+// Run runs the command for the alert, once the concurrency limit allows it.
+func (n *ExecNotifier) Run(a payload.Alert) error {
+	n.slots <- true
+	defer func() { <-n.slots }()
+
+	data, err := json.Marshal(NewWebhook(a))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, n.Config.Command, n.Config.Args...)
+	cmd.Env = append(os.Environ(), ExecEnv(a)...)
+	cmd.Stdin = bytes.NewReader(data)
+	// Do not wait for the children of a killed command that still hold
+	// its output, e.g. those started by a shell script
+	cmd.WaitDelay = time.Second
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("command %v failed: %v: %s", n.Config.Command, err, out)
+	}
+	return nil
+}
+
+// ExecEnv returns the environment variables describing the alert.
+func ExecEnv(a payload.Alert) []string {
+	h := NewWebhook(a)
+	env := []string{
+		"MONITOR_TYPE=" + h.Type,
+		"MONITOR_URL=" + h.URL,
+		"MONITOR_STATUS=" + h.Status,
+		"MONITOR_AVAILABILITY=" + strconv.FormatFloat(h.Availability, 'f', -1, 64),
+		"MONITOR_THRESHOLD=" + strconv.FormatFloat(h.Threshold, 'f', -1, 64),
+		"MONITOR_DATE=" + h.Date.Format(time.RFC3339),
+		"MONITOR_TIMEFRAME_START=" + h.Timeframe.Start.Format(time.RFC3339),
+		"MONITOR_TIMEFRAME_END=" + h.Timeframe.End.Format(time.RFC3339),
+		"MONITOR_TIMEFRAME_SECONDS=" + strconv.Itoa(h.Timeframe.Seconds),
+	}
+	if c := h.Certificate; c != nil {
+		env = append(env,
+			"MONITOR_CERTIFICATE_EXPIRES="+c.Expires.Format(time.RFC3339),
+			"MONITOR_CERTIFICATE_DAYS="+strconv.Itoa(c.DaysUntilExpiry),
+		)
+	}
+	return env
+}
@@ -0,0 +1,294 @@
+/*
+This file contains tests for the notification logic.
+*/
+
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Test of webhook notifications
+//
+// The receiver fails on the first request, so that the notifier has to retry.
+func TestWebhookNotifier(t *testing.T) {
+	var mu sync.Mutex
+	var received []Webhook
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var h Webhook
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+			t.Error(err)
+		}
+		received = append(received, h)
+	}))
+	defer receiver.Close()
+
+	n := &WebhookNotifier{
+		Endpoints: map[string][]string{testURL: []string{receiver.URL}},
+		Client:    &http.Client{Timeout: time.Second},
+		Retries:   2,
+		Backoff:   time.Millisecond,
+	}
+	tf := payload.NewTimeframe(20)
+	alerts := payload.Alerts{
+		{URL: testURL, Timeframe: tf, Availability: 0.5, Threshold: 0.8, BelowThreshold: true},
+		{URL: "http://no-webhook/", Timeframe: tf, Availability: 0, Threshold: 0.8, BelowThreshold: true},
+	}
+	if err := n.Notify(alerts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check the result
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %v", attempts)
+	}
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 webhook, got %v", received)
+	}
+	h := received[0]
+	if h.URL != testURL || h.Status != "down" || h.Availability != 0.5 || h.Threshold != 0.8 || h.Timeframe.Seconds != 20 {
+		t.Errorf("Unexpected webhook content: %+v", h)
+	}
+}
+
+// Test of webhook notifications when the receiver keeps failing
+func TestWebhookNotifierFailure(t *testing.T) {
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	n := &WebhookNotifier{
+		Endpoints: map[string][]string{testURL: []string{receiver.URL}},
+		Client:    &http.Client{Timeout: time.Second},
+		Retries:   2,
+		Backoff:   time.Millisecond,
+	}
+	if err := n.Notify(payload.Alerts{{URL: testURL}}); err == nil {
+		t.Error("Expected an error, got nil")
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %v", attempts)
+	}
+}
+
+// Test of the order of notifications
+//
+// The receiver fails on the first request, so that the "down" webhook is
+// retried: the "up" webhook of the next evaluation should still arrive last.
+func TestNotificationOrder(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var h Webhook
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+			t.Error(err)
+		}
+		received = append(received, h.Status)
+	}))
+	defer receiver.Close()
+
+	q := NewNotificationQueue(&WebhookNotifier{
+		Endpoints: map[string][]string{testURL: []string{receiver.URL}},
+		Client:    &http.Client{Timeout: time.Second},
+		Retries:   2,
+		Backoff:   50 * time.Millisecond,
+	})
+	Notify([]*NotificationQueue{q}, payload.Alerts{{URL: testURL, BelowThreshold: true}})
+	Notify([]*NotificationQueue{q}, payload.Alerts{{URL: testURL, BelowThreshold: false}})
+
+	// Wait for both webhooks to be received
+	for i := 0; i < 100; i++ {
+		mu.Lock()
+		n := len(received)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[0] != "down" || received[1] != "up" {
+		t.Errorf("Expected the down webhook before the up webhook, got %v", received)
+	}
+}
+
+// Test of email notifications
+//
+// Alerts are sent to a local SMTP stand-in, which records the received email.
+func TestEmailNotifier(t *testing.T) {
+	addr, received := serveSMTP(t)
+	n := NewEmailNotifier(EmailConfig{
+		Server:     addr,
+		From:       "monitor@example.com",
+		Recipients: []string{"oncall@example.com", "team@example.com"},
+	})
+	alerts := payload.Alerts{
+		{URL: testURL, Availability: 0.5, Threshold: 0.8, BelowThreshold: true},
+		{URL: "http://other/", Availability: 1, Threshold: 0.8, BelowThreshold: false},
+	}
+	if err := n.Notify(alerts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that both alerts were batched in a single email
+	mail := <-received
+	if len(mail.Recipients) != 2 {
+		t.Errorf("Expected 2 recipients, got %v", mail.Recipients)
+	}
+	for _, expected := range []string{
+		"Subject: [monitor] " + testURL + " is down, http://other/ is up",
+		"Website " + testURL + " is down.",
+		"Website http://other/ is up.",
+		"Availability: 0.500 (threshold: 0.800)",
+	} {
+		if !strings.Contains(mail.Data, expected) {
+			t.Errorf("Expected email to contain %q, got:\n%v", expected, mail.Data)
+		}
+	}
+}
+
+// Test of command execution on alerts
+//
+// The command writes its environment and standard input to a file,
+// which is then checked.
+func TestExecNotifier(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	n := NewExecNotifier(ExecConfig{
+		Command: "sh",
+		Args:    []string{"-c", `echo "$MONITOR_URL $MONITOR_STATUS $MONITOR_AVAILABILITY" > "$0"; cat >> "$0"`, out},
+	})
+	a := payload.Alert{URL: testURL, Availability: 0.5, Threshold: 0.8, BelowThreshold: true}
+	if err := n.Notify(payload.Alerts{a}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitN(string(data), "\n", 2)
+	if lines[0] != testURL+" down 0.5" {
+		t.Errorf("Unexpected environment: %v", lines[0])
+	}
+	var h Webhook
+	if err := json.Unmarshal([]byte(lines[1]), &h); err != nil || h.URL != testURL || h.Threshold != 0.8 {
+		t.Errorf("Unexpected standard input: %v (%v)", lines[1], err)
+	}
+}
+
+// Test of the command timeout
+//
+// The shell script starts a child that keeps the output of the command open
+// after the shell is killed.
+func TestExecNotifierTimeout(t *testing.T) {
+	// Create table of test cases
+	testCases := []struct {
+		command string
+		args    []string
+	}{
+		{"sleep", []string{"5"}},
+		{"sh", []string{"-c", "sleep 5; echo done"}},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			n := NewExecNotifier(ExecConfig{Command: tc.command, Args: tc.args})
+			n.Timeout = 50 * time.Millisecond
+			start := time.Now()
+			if err := n.Notify(payload.Alerts{{URL: testURL}}); err == nil {
+				t.Error("Expected an error, got nil")
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("Command was not killed after timeout (ran for %v)", d)
+			}
+		})
+	}
+}
+
+// receivedMail is an email received by the SMTP stand-in.
+type receivedMail struct {
+	Recipients []string
+	Data       string
+}
+
+// serveSMTP is a helper function that starts a minimal SMTP server,
+// which accepts a single email. It returns the address of the server and a
+// channel on which the received email is sent.
+func serveSMTP(t *testing.T) (string, chan receivedMail) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan receivedMail, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := textproto.NewConn(conn)
+		defer c.Close()
+
+		var mail receivedMail
+		c.PrintfLine("220 localhost ready")
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(strings.SplitN(line, " ", 2)[0]) {
+			case "EHLO", "HELO", "MAIL":
+				c.PrintfLine("250 OK")
+			case "RCPT":
+				mail.Recipients = append(mail.Recipients, line)
+				c.PrintfLine("250 OK")
+			case "DATA":
+				c.PrintfLine("354 Go ahead")
+				lines, _ := c.ReadDotLines()
+				mail.Data = strings.Join(lines, "\n")
+				c.PrintfLine("250 OK")
+				received <- mail
+			case "QUIT":
+				c.PrintfLine("221 Bye")
+				return
+			default:
+				c.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
@@ -0,0 +1,90 @@
+/*
+This file contains the policy logic, namely:
+- how user-defined policies are built from the config file
+- how poll results are checked against them
+*/
+
+package daemon
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A Policy defines additional criteria that a poll result must satisfy to be
+// considered valid, e.g. a 200 response that takes 8 seconds is not acceptable.
+//
+// Policies can only make checks stricter: a poll result that ended with
+// a client error or a 4xx/5xx response code is always invalid.
+type Policy struct {
+	MaxResponseTime      time.Duration // If not 0, slower responses are violations
+	MaxTTFB              time.Duration // If not 0, responses with a longer time to first byte are violations
+	AllowedStatusCodes   []int         // If not empty, other response codes are violations
+	ForbiddenStatusCodes []int         // Response codes that are violations
+}
+
+// NewPolicy creates a Policy from its configuration, falling back to the
+// default configuration for the criteria that are not filled.
+func NewPolicy(c, def PolicyConfig) Policy {
+	if c.MaxResponseTime == 0 {
+		c.MaxResponseTime = def.MaxResponseTime
+	}
+	if c.MaxTTFB == 0 {
+		c.MaxTTFB = def.MaxTTFB
+	}
+	if c.AllowedStatusCodes == nil {
+		c.AllowedStatusCodes = def.AllowedStatusCodes
+	}
+	if c.ForbiddenStatusCodes == nil {
+		c.ForbiddenStatusCodes = def.ForbiddenStatusCodes
+	}
+	return Policy{
+		MaxResponseTime:      time.Duration(c.MaxResponseTime) * time.Millisecond,
+		MaxTTFB:              time.Duration(c.MaxTTFB) * time.Millisecond,
+		AllowedStatusCodes:   c.AllowedStatusCodes,
+		ForbiddenStatusCodes: c.ForbiddenStatusCodes,
+	}
+}
+
+// Check returns a description of the first policy violation of the poll result,
+// or "" if the poll result complies with the policy.
+//
+// Poll results that ended with a client error are not checked, as they
+// have no response code nor meaningful timings. Response codes are only checked
+// for poll results that carry an HTTP response code: tcp and dns results have none,
+// and websocket results carry the 101 response code of the handshake.
+//
+// Descriptions mention the violated limit rather than the measured value,
+// so that violations of the same rule are counted together.
+func (pol Policy) Check(p PollResult) string {
+	if p.Error != nil {
+		return ""
+	}
+	if p.StatusCode != 0 && p.StatusCode != http.StatusSwitchingProtocols {
+		code := strconv.Itoa(p.StatusCode)
+		if len(pol.AllowedStatusCodes) != 0 && !containsCode(pol.AllowedStatusCodes, p.StatusCode) {
+			return "response code " + code + " is not allowed"
+		}
+		if containsCode(pol.ForbiddenStatusCodes, p.StatusCode) {
+			return "response code " + code + " is forbidden"
+		}
+	}
+	if pol.MaxResponseTime != 0 && p.Timing.Response > pol.MaxResponseTime {
+		return "response time above " + pol.MaxResponseTime.String()
+	}
+	if pol.MaxTTFB != 0 && p.Timing.TTFB > pol.MaxTTFB {
+		return "TTFB above " + pol.MaxTTFB.String()
+	}
+	return ""
+}
+
+// containsCode returns whether the response code is in the list.
+func containsCode(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+/*
+This file contains tests for the policy logic.
+*/
+
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Test of policy checks
+func TestPolicy(t *testing.T) {
+	pol := NewPolicy(
+		PolicyConfig{MaxResponseTime: 3000, ForbiddenStatusCodes: []int{302}},
+		PolicyConfig{MaxResponseTime: 5000, MaxTTFB: 1000},
+	)
+
+	// Create table of test cases
+	testCases := []struct {
+		result   PollResult
+		expected string
+	}{
+		{
+			// Fast 200 response: no violation
+			PollResult{StatusCode: 200, Timing: payload.Timing{Response: time.Second, TTFB: 500 * time.Millisecond}},
+			"",
+		},
+		{
+			// Slow 200 response: the website-specific limit is used
+			PollResult{StatusCode: 200, Timing: payload.Timing{Response: 8 * time.Second}},
+			"response time above 3s",
+		},
+		{
+			// Slow first byte: the default limit is used
+			PollResult{StatusCode: 200, Timing: payload.Timing{Response: 2 * time.Second, TTFB: 1500 * time.Millisecond}},
+			"TTFB above 1s",
+		},
+		{
+			// Forbidden response code
+			PollResult{StatusCode: 302},
+			"response code 302 is forbidden",
+		},
+		{
+			// Client errors are not checked
+			PollResult{Error: errors.New("dial tcp: i/o timeout"), Timing: payload.Timing{Response: 8 * time.Second}},
+			"",
+		},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			if computed := pol.Check(tc.result); computed != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, computed)
+			}
+		})
+	}
+}
+
+// Test of allowed response codes
+//
+// Policy violations should make poll results invalid, and be counted separately.
+func TestPolicyAllowedStatusCodes(t *testing.T) {
+	pol := NewPolicy(PolicyConfig{AllowedStatusCodes: []int{200, 204}}, PolicyConfig{})
+	var p []PollResult
+	for _, code := range []int{200, 204, 301, 301, 503} {
+		r := PollResult{StatusCode: code}
+		r.Violation = pol.Check(r)
+		p = append(p, r)
+	}
+
+	// Results without an HTTP response code (tcp, dns) or with the handshake
+	// response code of a websocket check are not checked against the response codes
+	for _, code := range []int{0, 101} {
+		if v := pol.Check(PollResult{StatusCode: code}); v != "" {
+			t.Errorf("Expected no violation for response code %v, got %q", code, v)
+		}
+	}
+
+	if avail := Availability(p); avail != 0.4 {
+		t.Errorf("Expected availability 0.4, got %v", avail)
+	}
+	violations := CountViolations(p)
+	if len(violations) != 2 || violations["response code 301 is not allowed"] != 2 || violations["response code 503 is not allowed"] != 1 {
+		t.Errorf("Unexpected violations: %v", violations)
+	}
+}
@@ -1,7 +1,6 @@
 /*
 This file contains the polling logic, namely:
 - how and when websites are polled
-- how metrics are collected throughout the lifecycle of an HTTP request
 - how poll results are saved (to allow for later analysis and aggregation)
 */
 
@@ -9,13 +8,7 @@ package daemon
 
 import (
 	"fmt"
-	"io/ioutil"
-	"net"
-	"net/http"
-	"net/http/httptrace"
 	"time"
-
-	"github.com/anatolebeuzon/monitor/internal/payload"
 )
 
 // SchedulePolls schedules regular polls for the website. It never returns.
@@ -26,100 +19,66 @@ func (w *Website) SchedulePolls() {
 	}
 }
 
-// Poll makes a GET request to a website, measuring various times
-// throughout the HTTP request, and reading the HTTP response code.
+// Poll probes the website with its checker, checks the result
+// against the website's policy, and saves it.
+//
+// If the result is invalid, the website is probed again after RetryDelay,
+// up to Retries times, and only the result of the last attempt is saved.
 func (w *Website) Poll() {
-
-	// Create request
-	req, err := http.NewRequest("GET", w.URL, nil)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	// Record the exact times when the different parts of the request are reached
-	var t [7]time.Time // t will store those times
-	trace := &httptrace.ClientTrace{
-		DNSStart:             func(_ httptrace.DNSStartInfo) { t[0] = time.Now() },
-		DNSDone:              func(_ httptrace.DNSDoneInfo) { t[1] = time.Now() },
-		ConnectStart:         func(_, _ string) { t[2] = time.Now() },
-		ConnectDone:          func(_, _ string, _ error) { t[3] = time.Now() },
-		GotConn:              func(_ httptrace.GotConnInfo) { t[4] = time.Now() },
-		GotFirstResponseByte: func() { t[5] = time.Now() },
-	}
-
-	// Execute request and read response
 	var p PollResult
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
-	resp, err := NewTransport().RoundTrip(req)
-	if err != nil {
-		p.Error = err
-	} else {
-		p.StatusCode = resp.StatusCode
-		ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
-		t[6] = time.Now() // records the fact that the body has been read (response is over)
-	}
+	for attempt := 1; ; attempt++ {
+		p = w.Checker.Check()
+		p.Attempts = attempt
 
-	// If an error occured, some times of the t slice may still be at 0.
-	// However, they must be set to a sensible time to avoid getting
-	// absurd results when converting those times to meaningful durations.
-	if t[0].IsZero() {
-		t[0] = time.Now()
-	}
-	for i := range t {
-		if (i > 0) && t[i].IsZero() {
-			t[i] = t[i-1]
-		}
-	}
+		// Check the response against the website's policy
+		p.Violation = w.Policy.Check(p)
 
-	p.Date = t[0]
-
-	// Convert the recorded times to meaningful durations
-	p.Timing = payload.Timing{
-		DNS:      t[1].Sub(t[0]),
-		TCP:      t[3].Sub(t[2]),
-		TLS:      t[4].Sub(t[3]),
-		Server:   t[5].Sub(t[4]),
-		Transfer: t[6].Sub(t[5]),
-		TTFB:     t[5].Sub(t[0]),
-		Response: t[6].Sub(t[0]),
+		if IsValid(p) || attempt > w.Retries {
+			break
+		}
+		time.Sleep(w.RetryDelay)
 	}
 
 	// Save the poll result at the end of the website's poll results
 	w.SaveResult(&p)
 }
 
-// NewTransport creates a new http.Transport.
-//
-// It purposefully has low timeouts, to allow for quick error detection and alerting.
-// Keep-alive is also disabled, to ensure that processes such as DNS lookup
-// and TLS handshakes are tested at each request.
-func NewTransport() *http.Transport {
-	return &http.Transport{
-		DisableKeepAlives: true,
-		DialContext: (&net.Dialer{
-			Timeout:   4 * time.Second,
-			KeepAlive: 4 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		IdleConnTimeout:     4 * time.Second,
-		TLSHandshakeTimeout: 4 * time.Second,
-	}
-}
-
 // SaveResult saves a PollResult at the end of a websites' PollResults.
 //
 // If the number of poll results exceeds the user-defined retainedResults parameter,
 // the oldest items are deleted.
 // If retainedResults = 0, no metric is ever deleted.
 func (w *Website) SaveResult(p *PollResult) {
-	w.PollResults.Lock()
-	defer w.PollResults.Unlock()
+	if err := w.PollResults.Save(*p, w.RetainedResults); err != nil {
+		fmt.Println("Could not save poll result of", w.URL, ":", err)
+	}
+}
+
+// Save saves a PollResult at the end of the in-memory poll results.
+//
+// If retained is not 0 and the number of poll results exceeds it,
+// the oldest items are deleted.
+func (r *PollResults) Save(p PollResult, retained int) error {
+	r.Lock()
+	defer r.Unlock()
+
+	i := 0
+	if (retained != 0) && (len(r.items) >= retained) {
+		i = len(r.items) + 1 - retained
+	}
+	r.items = append(r.items[i:], p)
+	return nil
+}
+
+// Expire deletes the in-memory poll results that are older than the provided date.
+func (r *PollResults) Expire(before time.Time) error {
+	r.Lock()
+	defer r.Unlock()
 
 	i := 0
-	if (w.RetainedResults != 0) && (len(w.PollResults.items) >= w.RetainedResults) {
-		i = len(w.PollResults.items) + 1 - w.RetainedResults
+	for i < len(r.items) && r.items[i].Date.Before(before) {
+		i++
 	}
-	w.PollResults.items = append(w.PollResults.items[i:], *p)
+	r.items = r.items[i:]
+	return nil
 }
@@ -0,0 +1,104 @@
+/*
+This file contains tests for the polling logic.
+*/
+
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Test of the request sent to the website
+//
+// The server checks the method, headers and body of the request,
+// and replies with a 400 response code if any of them is unexpected.
+func TestPollRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || r.Host != "health.example.com" ||
+			r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Token") != "abc" ||
+			string(body) != `{"deep": true}` {
+			t.Errorf("Unexpected request: %v %v %v %s", r.Method, r.Host, r.Header, body)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	checker, err := NewChecker(WebsiteConfig{
+		URL:    server.URL,
+		Method: "POST",
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"x-token":      "abc",
+			"host":         "health.example.com",
+		},
+		Body: `{"deep": true}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := Website{URL: server.URL, PollResults: &PollResults{}, Checker: checker}
+	w.Poll()
+
+	p := w.PollResults.Extract(payload.NewTimeframe(10))
+	if len(p) != 1 || p[0].StatusCode != 200 {
+		t.Errorf("Unexpected poll results: %v", p)
+	}
+}
+
+// Test of the retries of failed polls
+//
+// The checker fails a given number of times before succeeding.
+func TestPollRetries(t *testing.T) {
+	// Create table of test cases
+	testCases := []struct {
+		retries  int
+		failures int  // Number of failed attempts before the checker succeeds
+		attempts int  // Expected number of attempts
+		valid    bool // Whether the saved poll result should be valid
+	}{
+		{0, 0, 1, true},
+		{0, 1, 1, false}, // Retries are disabled
+		{2, 1, 2, true},  // Recovered after a retry
+		{2, 5, 3, false}, // Still failing after all retries
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			calls := 0
+			checker := CheckerFunc(func() PollResult {
+				calls++
+				if calls <= tc.failures {
+					return PollResult{Date: time.Now(), Error: errors.New("connection reset")}
+				}
+				return PollResult{Date: time.Now(), StatusCode: 200}
+			})
+			w := Website{PollResults: &PollResults{}, Checker: checker, Retries: tc.retries, RetryDelay: time.Millisecond}
+			w.Poll()
+
+			p := w.PollResults.Extract(payload.NewTimeframe(10))
+			if len(p) != 1 || calls != tc.attempts {
+				t.Fatalf("Expected 1 poll result after %v attempts, got %v after %v", tc.attempts, p, calls)
+			}
+			if p[0].Attempts != tc.attempts || IsValid(p[0]) != tc.valid {
+				t.Errorf("Expected %v attempts and valid=%v, got %+v", tc.attempts, tc.valid, p[0])
+			}
+			recovered := 0
+			if tc.valid && tc.attempts > 1 {
+				recovered = 1
+			}
+			if m := w.Aggregate(payload.NewTimeframe(10)); m.Recovered != recovered {
+				t.Errorf("Expected %v recovered poll results, got %v", recovered, m.Recovered)
+			}
+		})
+	}
+}
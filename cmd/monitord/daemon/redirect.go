@@ -0,0 +1,166 @@
+/*
+This file contains the redirect logic, namely:
+- how the redirect policy of a website is built from the config file
+- how redirects are followed, and the corresponding chain recorded
+- how redirect chains are aggregated for the dashboard
+*/
+
+package daemon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// RedirectPolicy defines how the redirect responses of a website are handled.
+type RedirectPolicy struct {
+	Max      int            // Maximum number of redirects followed. If 0, redirects are not followed
+	Forbid   bool           // Whether redirect responses make poll results invalid
+	FinalURL *regexp.Regexp // Pattern that the URL of the final response must match. If nil, any URL is accepted
+}
+
+// NewRedirectPolicy creates a RedirectPolicy from the config file.
+func NewRedirectPolicy(c RedirectConfig) (r RedirectPolicy, err error) {
+	if c.Max < 0 {
+		return r, errors.New("Redirects.Max cannot be negative")
+	}
+	if c.Forbid && c.Max != 0 {
+		return r, errors.New("Redirects.Forbid cannot be used with Redirects.Max")
+	}
+	r.Max, r.Forbid = c.Max, c.Forbid
+	if c.FinalURL != "" {
+		r.FinalURL, err = regexp.Compile(c.FinalURL)
+	}
+	return
+}
+
+// IsRedirect returns whether an HTTP response code is a redirect that can be followed.
+func IsRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// FollowRedirects executes a request and, according to the redirect policy,
+// the requests of the redirects that it leads to. It returns the poll result
+// of the whole chain, and the body of the final response.
+//
+// The timings of the poll result are the sums of the timings of the hops,
+// and its status code is the one of the final response.
+// The certificate is the one presented by the requested website.
+//
+// The overall timeout applies to the whole chain, while the other timeouts apply to each hop.
+func (h *HTTPChecker) FollowRedirects(req *http.Request, rt http.RoundTripper) (p PollResult, body []byte) {
+	chain := NewExpiry(req.Context())
+	defer chain.Cancel()
+	defer chain.Start(TimeoutOverall, h.Timeouts.Overall)()
+	hopTimeouts := h.Timeouts
+	hopTimeouts.Overall = 0
+
+	for {
+		hp, resp, b := TimedRoundTrip(req.WithContext(chain.Context), rt, h.TLSConfig, hopTimeouts)
+		if err := chain.Err(); hp.Error != nil && err != nil {
+			hp.Error = err
+		}
+		if len(p.Hops) == 0 {
+			p.Date, p.Certificate = hp.Date, hp.Certificate
+		}
+		p.StatusCode, p.Error, body = hp.StatusCode, hp.Error, b
+		p.Timing = AddTiming(p.Timing, hp.Timing)
+		p.Hops = append(p.Hops, payload.Hop{URL: req.URL.String(), StatusCode: hp.StatusCode, Timing: hp.Timing})
+		if hp.Error != nil || !IsRedirect(resp.StatusCode) || resp.Header.Get("Location") == "" {
+			break
+		}
+
+		location, err := req.URL.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			p.Error = err
+			break
+		}
+		if h.Redirects.Forbid {
+			p.Error = fmt.Errorf("redirect to %v is forbidden", location)
+			break
+		}
+		if h.Redirects.Max == 0 {
+			break // Redirects are not followed
+		}
+		if len(p.Hops) > h.Redirects.Max {
+			p.Error = fmt.Errorf("more than %v redirects", h.Redirects.Max)
+			break
+		}
+		if req, err = h.RedirectRequest(req, resp.StatusCode, location.String()); err != nil {
+			p.Error = err
+			break
+		}
+	}
+
+	if len(p.Hops) == 1 {
+		p.Hops = nil // No redirect was followed
+	}
+	if final := req.URL.String(); p.Error == nil && h.Redirects.FinalURL != nil && !h.Redirects.FinalURL.MatchString(final) {
+		p.Error = fmt.Errorf("final URL %v does not match %q", final, h.Redirects.FinalURL.String())
+	}
+	return
+}
+
+// RedirectRequest creates the request following a redirect response, as browsers do:
+// 301, 302 and 303 redirects are followed with a GET request without body (except
+// for HEAD requests), while 307 and 308 redirects preserve the method and body.
+//
+// The headers of the config file and the credentials are only sent again if the
+// redirect leads to the same host, as they may contain secrets, e.g. an API key.
+func (h *HTTPChecker) RedirectRequest(prev *http.Request, code int, location string) (*http.Request, error) {
+	method, body := prev.Method, h.Body
+	if code != http.StatusTemporaryRedirect && code != http.StatusPermanentRedirect {
+		if method != "HEAD" {
+			method = "GET"
+		}
+		body = nil
+	}
+	req, err := h.newRequest(method, location, body)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Host != prev.URL.Host {
+		var b io.Reader
+		if len(body) != 0 {
+			b = bytes.NewReader(body)
+		}
+		return http.NewRequest(method, location, b)
+	}
+	if h.Auth != nil {
+		err = h.Auth.Authenticate(req)
+	}
+	return req, err
+}
+
+// AggregateHops returns the redirect chain of the latest poll result, with the times
+// of each hop averaged over the poll results that went through the same URL at the same step.
+func AggregateHops(p []PollResult) []payload.Hop {
+	if len(p) == 0 || len(p[len(p)-1].Hops) == 0 {
+		return nil
+	}
+	latest := p[len(p)-1].Hops
+	hops := make([]payload.Hop, len(latest))
+	for i, hop := range latest {
+		var sum payload.Timing
+		n := 0
+		for _, r := range p {
+			if i < len(r.Hops) && r.Hops[i].URL == hop.URL {
+				sum = AddTiming(sum, r.Hops[i].Timing)
+				n++
+			}
+		}
+		hops[i] = payload.Hop{URL: hop.URL, StatusCode: hop.StatusCode, Timing: DivideTiming(sum, n)}
+	}
+	return hops
+}
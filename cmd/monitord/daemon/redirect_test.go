@@ -0,0 +1,186 @@
+/*
+This file contains tests for the redirect logic.
+*/
+
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Test of the redirect policy of HTTP checks
+//
+// /old is moved to /new, /loop redirects to itself, and /post and /see-other
+// redirect to /echo (with a 307 and a 303 response code), which replies
+// with the method and body of the request.
+func TestFollowRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/new", http.StatusMovedPermanently))
+	mux.Handle("/loop", http.RedirectHandler("/loop", http.StatusFound))
+	mux.Handle("/post", http.RedirectHandler("/echo", http.StatusTemporaryRedirect))
+	mux.Handle("/see-other", http.RedirectHandler("/echo", http.StatusSeeOther))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%v %s", r.Method, body)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Create table of test cases
+	testCases := []struct {
+		path      string
+		redirects RedirectConfig
+		expected  string // Expected error, or "" if the check should succeed
+		code      int    // Expected status code
+		hops      int    // Expected number of hops
+	}{
+		{"/old", RedirectConfig{}, "", 301, 0}, // Redirects are not followed by default
+		{"/old", RedirectConfig{Max: 5}, "", 200, 2},
+		{"/old", RedirectConfig{Forbid: true}, "redirect to " + server.URL + "/new is forbidden", 301, 0},
+		{"/new", RedirectConfig{Forbid: true}, "", 200, 0},
+		{"/loop", RedirectConfig{Max: 3}, "more than 3 redirects", 302, 4},
+		{"/old", RedirectConfig{Max: 1, FinalURL: "/login$"}, "final URL " + server.URL + `/new does not match "/login$"`, 200, 2},
+		{"/old", RedirectConfig{Max: 1, FinalURL: "/new$"}, "", 200, 2},
+		{"/post", RedirectConfig{Max: 1}, "", 200, 2},      // Method and body are preserved
+		{"/see-other", RedirectConfig{Max: 1}, "", 200, 2}, // Method is changed to GET
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			c := WebsiteConfig{URL: server.URL + tc.path, Method: "POST", Body: "payload", Redirects: tc.redirects}
+			switch tc.path {
+			case "/post":
+				c.Assertions = []AssertionConfig{{Contains: "POST payload"}}
+			case "/see-other":
+				c.Assertions = []AssertionConfig{{Regex: "^GET $"}}
+			}
+			checker, err := NewChecker(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := checker.Check()
+			computed := ""
+			if p.Error != nil {
+				computed = p.Error.Error()
+			}
+			if computed != tc.expected || p.StatusCode != tc.code || len(p.Hops) != tc.hops {
+				t.Errorf("Expected %q, %v, %v hops, got %q, %v, %+v", tc.expected, tc.code, tc.hops, computed, p.StatusCode, p.Hops)
+			}
+			if len(p.Hops) != 0 && p.Hops[0].URL != c.URL {
+				t.Errorf("Expected chain to start at %v, got %v", c.URL, p.Hops[0].URL)
+			}
+		})
+	}
+}
+
+// Test of invalid redirect policies, which should be rejected on startup
+func TestInvalidRedirectConfig(t *testing.T) {
+	for _, c := range []RedirectConfig{
+		{Max: -1},
+		{Max: 2, Forbid: true},
+		{FinalURL: "("},
+	} {
+		if _, err := NewRedirectPolicy(c); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
+
+// Test of the aggregation of redirect chains
+//
+// Hops are averaged over the poll results that went through the same URL
+// at the same step of the chain, following the chain of the latest poll result.
+func TestAggregateHops(t *testing.T) {
+	hop := func(url string, code int, ms time.Duration) payload.Hop {
+		return payload.Hop{URL: url, StatusCode: code, Timing: payload.Timing{Response: ms * time.Millisecond}}
+	}
+
+	// Create table of test cases
+	testCases := []struct {
+		results  []PollResult
+		expected []payload.Hop
+	}{
+		{nil, nil},
+		{[]PollResult{{Hops: []payload.Hop{hop("/a", 301, 10), hop("/b", 200, 20)}}, {}}, nil}, // Latest poll result was not redirected
+		{
+			[]PollResult{
+				{Hops: []payload.Hop{hop("/a", 301, 10), hop("/b", 200, 20)}},
+				{Hops: []payload.Hop{hop("/a", 302, 30), hop("/c", 200, 100)}},
+				{Hops: []payload.Hop{hop("/a", 301, 20), hop("/b", 200, 40)}},
+			},
+			[]payload.Hop{hop("/a", 301, 20), hop("/b", 200, 30)},
+		},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			computed := AggregateHops(tc.results)
+			if fmt.Sprint(computed) != fmt.Sprint(tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, computed)
+			}
+		})
+	}
+}
+
+// Test of the credentials sent after a redirect to another host
+//
+// Neither the headers of the config file nor the credentials of Auth
+// should be sent to another host.
+func TestRedirectRequest(t *testing.T) {
+	os.Setenv("MONITOR_TEST_PASSWORD", "secret")
+	defer os.Unsetenv("MONITOR_TEST_PASSWORD")
+	auth, err := NewAuthenticator(AuthConfig{Type: "basic", Username: "user", Password: SecretConfig{Env: "MONITOR_TEST_PASSWORD"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &HTTPChecker{URL: "https://example.com/a", Method: "GET", Headers: map[string]string{"X-Api-Key": "key"}, Auth: auth}
+	prev, _ := h.NewRequest()
+	for _, tc := range []struct {
+		location    string
+		credentials bool // Whether the headers and credentials should be sent
+	}{
+		{"https://example.com/b", true},
+		{"https://other.example.com/b", false},
+	} {
+		req, err := h.RedirectRequest(prev, http.StatusFound, tc.location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, hasAuth := req.BasicAuth()
+		if hasKey := req.Header.Get("X-Api-Key") != ""; hasKey != tc.credentials || hasAuth != tc.credentials || !strings.HasSuffix(req.URL.String(), "/b") {
+			t.Errorf("Expected credentials=%v for %v, got headers %v", tc.credentials, tc.location, req.Header)
+		}
+	}
+}
+
+// Test of the overall timeout of a redirect chain
+//
+// Each hop takes 60ms, which is below the overall timeout,
+// but the whole chain takes more.
+func TestRedirectChainTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+		http.Redirect(w, r, "/next", http.StatusFound)
+	}))
+	defer server.Close()
+
+	checker, err := NewChecker(WebsiteConfig{URL: server.URL, Redirects: RedirectConfig{Max: 10}, Timeouts: TimeoutConfig{Overall: 150}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := checker.Check(); fmt.Sprint(p.Error) != "overall timeout (150ms)" {
+		t.Errorf("Expected an overall timeout, got %v", p.Error)
+	}
+}
@@ -0,0 +1,422 @@
+/*
+This file contains the time-based retention logic, namely:
+- how poll results are summarized into rollups of a fixed resolution
+- how rollups and raw poll results expire
+- how rollups are persisted on disk
+*/
+
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// RollupDelay is the delay after which a time bucket is summarized,
+// to leave time for the polls started at the end of the bucket to complete.
+const RollupDelay = 30 * time.Second
+
+// A Rollup summarizes the poll results of a website over a time bucket.
+//
+// It keeps enough information to compute the availability, the average
+// and maximum timings (of all, valid and invalid poll results), the response
+// code and error counts, and the response time distribution of the bucket,
+// and to merge these statistics with those of other buckets.
+type Rollup struct {
+	Date             time.Time      // Start date of the bucket
+	Count            int            // Number of poll results
+	Valid            int            // Number of valid poll results
+	Recovered        int            // Number of valid poll results that needed more than one attempt
+	Sum              payload.Timing // Sum of the timings, used to compute averages
+	Max              payload.Timing // Max timings
+	ValidSum         payload.Timing // Sum of the timings of valid poll results
+	ValidMax         payload.Timing // Max timings of valid poll results
+	InvalidMax       payload.Timing // Max timings of invalid poll results (their sum is Sum - ValidSum)
+	StatusCodeCounts map[int]int
+	ErrorCounts      map[string]int
+	Violations       map[string]int
+	Histogram        []int // Number of poll results in each bucket of the response time distribution
+}
+
+// RollupSeries contains the rollups of a website at a given resolution.
+type RollupSeries struct {
+	sync.RWMutex
+	Resolution time.Duration   // Duration summarized by each rollup
+	Keep       time.Duration   // Duration during which rollups are kept. If 0, rollups are never deleted
+	Buckets    []time.Duration // Upper bounds of the buckets of the response time distribution
+
+	items   []Rollup  // Rollups, sorted by increasing date
+	next    time.Time // Start date of the next bucket to summarize
+	path    string    // File in which rollups are persisted, or "" if they are only kept in memory
+	file    *os.File  // File opened for appending
+	expired int       // Number of rollups that expired since the file was last rewritten
+}
+
+// NewRollup summarizes the poll results into a Rollup starting at date.
+// The response time distribution is computed using the provided bucket upper bounds.
+func NewRollup(date time.Time, p []PollResult, buckets []time.Duration) Rollup {
+	r := Rollup{
+		Date:             date,
+		Count:            len(p),
+		Valid:            CountValid(p),
+		Recovered:        CountRecovered(p),
+		Max:              Max(p),
+		StatusCodeCounts: CountCodes(p),
+		ErrorCounts:      CountErrors(p),
+		Violations:       CountViolations(p),
+		Histogram:        Histogram(p, buckets),
+	}
+	for _, result := range p {
+		r.Sum = AddTiming(r.Sum, result.Timing)
+		if IsValid(result) {
+			r.ValidSum = AddTiming(r.ValidSum, result.Timing)
+			r.ValidMax = MaxTiming(r.ValidMax, result.Timing)
+		} else {
+			r.InvalidMax = MaxTiming(r.InvalidMax, result.Timing)
+		}
+	}
+	return r
+}
+
+// Merge adds the statistics of another rollup to the rollup.
+func (r *Rollup) Merge(o Rollup) {
+	r.Count += o.Count
+	r.Valid += o.Valid
+	r.Recovered += o.Recovered
+	r.Sum = AddTiming(r.Sum, o.Sum)
+	r.Max = MaxTiming(r.Max, o.Max)
+	r.ValidSum = AddTiming(r.ValidSum, o.ValidSum)
+	r.ValidMax = MaxTiming(r.ValidMax, o.ValidMax)
+	r.InvalidMax = MaxTiming(r.InvalidMax, o.InvalidMax)
+
+	if r.StatusCodeCounts == nil {
+		r.StatusCodeCounts = make(map[int]int)
+	}
+	for code, c := range o.StatusCodeCounts {
+		r.StatusCodeCounts[code] += c
+	}
+	if r.ErrorCounts == nil {
+		r.ErrorCounts = make(map[string]int)
+	}
+	for err, c := range o.ErrorCounts {
+		r.ErrorCounts[err] += c
+	}
+	if r.Violations == nil {
+		r.Violations = make(map[string]int)
+	}
+	for v, c := range o.Violations {
+		r.Violations[v] += c
+	}
+
+	// If bucket bounds were changed in the config file, older rollups may have
+	// a different number of buckets: only the common buckets are merged
+	for i := 0; i < len(r.Histogram) && i < len(o.Histogram); i++ {
+		r.Histogram[i] += o.Histogram[i]
+	}
+}
+
+// Metric returns the statistics of the rollup as a payload.Metric,
+// using the provided bucket upper bounds for the response time distribution.
+func (r Rollup) Metric(buckets []time.Duration) payload.Metric {
+	invalid := r.Count - r.Valid
+	m := payload.Metric{
+		All: payload.TimingStats{
+			Count:   r.Count,
+			Average: DivideTiming(r.Sum, r.Count),
+			Max:     r.Max,
+		},
+		Valid: payload.TimingStats{
+			Count:   r.Valid,
+			Average: DivideTiming(r.ValidSum, r.Valid),
+			Max:     r.ValidMax,
+		},
+		Invalid: payload.TimingStats{
+			Count:   invalid,
+			Average: DivideTiming(SubtractTiming(r.Sum, r.ValidSum), invalid),
+			Max:     r.InvalidMax,
+		},
+		StatusCodeCounts: r.StatusCodeCounts,
+		ErrorCounts:      r.ErrorCounts,
+		PolicyViolations: r.Violations,
+		Recovered:        r.Recovered,
+	}
+	for i, c := range r.Histogram {
+		b := payload.Bucket{Count: c}
+		if i < len(buckets) {
+			b.Max = buckets[i]
+		}
+		m.Histogram = append(m.Histogram, b)
+	}
+	if r.Count != 0 {
+		// As in Availability, a website with no poll result is considered down
+		m.Availability = float64(r.Valid) / float64(r.Count)
+	}
+	return m
+}
+
+// OpenRollupSeries creates a new RollupSeries from its configuration.
+//
+// If path is not empty, the rollups previously saved in the file at path
+// are loaded, and new rollups are appended to it.
+func OpenRollupSeries(c RollupConfig, path string) (*RollupSeries, error) {
+	s := &RollupSeries{
+		Resolution: time.Duration(c.Resolution) * time.Second,
+		Keep:       time.Duration(c.Keep) * time.Second,
+		path:       path,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	err := ReadJSONLines(path, true, func(line []byte) error {
+		var r Rollup
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		s.items = append(s.items, r)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Update summarizes into rollups, for each complete bucket that was not
+// summarized yet, the rollups of the finer series, or the poll results
+// of the store if finer is nil.
+//
+// Coarse rollups are built from finer ones rather than from poll results,
+// as poll results may expire before the end of a coarse bucket.
+func (s *RollupSeries) Update(store ResultStore, finer *RollupSeries, now time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	end := now.Add(-RollupDelay).Truncate(s.Resolution) // End of the last complete bucket
+	if s.next.IsZero() {
+		// Start after the last rollup, or at the bucket of the oldest source
+		if n := len(s.items); n != 0 {
+			s.next = s.items[n-1].Date.Add(s.Resolution)
+		} else if r := finer.Extract(time.Time{}, end); len(r) != 0 {
+			s.next = r[0].Date.Truncate(s.Resolution)
+		} else if p := store.Extract(payload.Timeframe{EndDate: end}); finer == nil && len(p) != 0 {
+			s.next = p[0].Date.Truncate(s.Resolution)
+		} else {
+			return nil
+		}
+	}
+
+	// Summarize each bucket, skipping the buckets without poll results
+	var p []PollResult
+	var rollups []Rollup
+	if finer != nil {
+		rollups = finer.Extract(s.next, end)
+	} else {
+		p = store.Extract(payload.Timeframe{StartDate: s.next, EndDate: end})
+	}
+	for s.next.Before(end) {
+		bucketEnd := s.next.Add(s.Resolution)
+		r := Rollup{Date: s.next, Histogram: make([]int, len(s.Buckets)+1)}
+		i := 0
+		if finer != nil {
+			for i < len(rollups) && rollups[i].Date.Before(bucketEnd) {
+				r.Merge(rollups[i])
+				i++
+			}
+			rollups = rollups[i:]
+		} else {
+			for i < len(p) && p[i].Date.Before(bucketEnd) {
+				i++
+			}
+			r = NewRollup(s.next, p[:i], s.Buckets)
+			p = p[i:]
+		}
+		if i != 0 {
+			if err := s.add(r); err != nil {
+				return err
+			}
+		}
+		s.next = bucketEnd
+	}
+	return nil
+}
+
+// add saves a rollup at the end of the series, and appends it to the file.
+func (s *RollupSeries) add(r Rollup) error {
+	s.items = append(s.items, r)
+	if s.file == nil {
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err = s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Expire deletes the rollups that are older than s.Keep.
+//
+// To avoid rewriting the file at each call, the file is only rewritten
+// once it contains more expired rollups than retained ones.
+func (s *RollupSeries) Expire(now time.Time) error {
+	if s.Keep == 0 {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	i := 0
+	for i < len(s.items) && s.items[i].Date.Before(now.Add(-s.Keep)) {
+		i++
+	}
+	s.items = s.items[i:]
+	s.expired += i
+
+	if s.file != nil && s.expired > len(s.items) {
+		return s.rewrite()
+	}
+	return nil
+}
+
+// rewrite replaces the file with a new one containing only the current rollups.
+// The new file is renamed over the old one, so that a crash in the middle
+// of the rewrite does not lose any rollup.
+func (s *RollupSeries) rewrite() error {
+	tmp, err := os.Create(s.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	for _, r := range s.items {
+		data, err := json.Marshal(r)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err = tmp.Write(append(data, '\n')); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(s.path+".tmp", s.path); err != nil {
+		return err
+	}
+
+	// Reopen the new file for appending
+	s.file.Close()
+	if s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	s.expired = 0
+	return nil
+}
+
+// Extract returns the rollups whose bucket is entirely included
+// between the start and end dates. A nil series has no rollup.
+func (s *RollupSeries) Extract(start, end time.Time) []Rollup {
+	if s == nil {
+		return nil
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	var startIdx, endIdx int
+	for startIdx < len(s.items) && s.items[startIdx].Date.Before(start) {
+		startIdx++
+	}
+	for endIdx = startIdx; endIdx < len(s.items); endIdx++ {
+		if s.items[endIdx].Date.Add(s.Resolution).After(end) {
+			break
+		}
+	}
+	return s.items[startIdx:endIdx]
+}
+
+// CheckRetention returns an error if data may expire before being summarized:
+// poll results must be kept (both by duration and by count) for the resolution
+// of the finest rollup, and the rollups of each series for the resolution of the
+// next one, plus RollupDelay. Each resolution must also be a multiple of the
+// previous one, so that the buckets of a series fit in those of the next one.
+func (w *Website) CheckRetention() error {
+	if len(w.Rollups) == 0 {
+		return nil
+	}
+	finest := w.Rollups[0].Resolution
+	if finest <= 0 {
+		return errors.New("rollup resolutions must be positive")
+	}
+	if w.RawRetention != 0 && w.RawRetention < finest+RollupDelay {
+		return fmt.Errorf("raw retention must be at least %v to build %v rollups", finest+RollupDelay, finest)
+	}
+	if kept := time.Duration(w.RetainedResults*w.Interval) * time.Second; w.RetainedResults != 0 && kept < finest+RollupDelay {
+		return fmt.Errorf("%v retained results only cover %v, not enough to build %v rollups", w.RetainedResults, kept, finest)
+	}
+	for i := 1; i < len(w.Rollups); i++ {
+		finer, s := w.Rollups[i-1], w.Rollups[i]
+		if s.Resolution%finer.Resolution != 0 {
+			return fmt.Errorf("rollup resolution %v is not a multiple of %v", s.Resolution, finer.Resolution)
+		}
+		if finer.Keep != 0 && finer.Keep < s.Resolution+RollupDelay {
+			return fmt.Errorf("%v rollups must be kept for at least %v to build %v rollups", finer.Resolution, s.Resolution+RollupDelay, s.Resolution)
+		}
+	}
+	return nil
+}
+
+// ScheduleRetention regularly summarizes the poll results of the website
+// into rollups, and deletes the poll results and rollups that have expired.
+// It never returns.
+func (w *Website) ScheduleRetention() {
+	// Run at least once per minute, and once per rollup for finer resolutions
+	tick := time.Minute
+	for _, s := range w.Rollups {
+		if s.Resolution < tick {
+			tick = s.Resolution
+		}
+	}
+	for now := range time.Tick(tick) {
+		w.ApplyRetention(now)
+	}
+}
+
+// ApplyRetention summarizes the poll results of the website into rollups,
+// and then deletes the poll results and rollups that have expired.
+//
+// The finest rollups are built from poll results, and each coarser
+// series from the rollups of the previous one.
+func (w *Website) ApplyRetention(now time.Time) {
+	var finer *RollupSeries
+	for _, s := range w.Rollups {
+		err := s.Update(w.PollResults, finer, now)
+		finer = s
+		if err != nil {
+			fmt.Println("Could not save rollup of", w.URL, ":", err)
+		}
+		if err := s.Expire(now); err != nil {
+			fmt.Println("Could not expire rollups of", w.URL, ":", err)
+		}
+	}
+	if w.RawRetention != 0 {
+		if err := w.PollResults.Expire(now.Add(-w.RawRetention)); err != nil {
+			fmt.Println("Could not expire poll results of", w.URL, ":", err)
+		}
+	}
+}
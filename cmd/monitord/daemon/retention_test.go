@@ -0,0 +1,170 @@
+/*
+This file contains tests for the time-based retention logic.
+*/
+
+package daemon
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Test of downsampling
+//
+// It creates 10 minutes of poll results, only keeps the last 5 minutes
+// of raw poll results, and checks that aggregating the 10 minutes
+// from raw poll results and rollups gives the same statistics, except for
+// percentiles, which cannot be computed from rollups.
+func TestRetention(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	start := now.Add(-10 * time.Minute)
+	var results []PollResult
+	for d := time.Duration(0); d < 10*time.Minute; d += 10 * time.Second {
+		p := PollResult{Date: start.Add(d), StatusCode: 200, Timing: payload.Timing{Response: d / 1000}}
+		if d%time.Minute == 0 {
+			p.StatusCode = 500
+		}
+		results = append(results, p)
+	}
+	tf := payload.Timeframe{StartDate: start, EndDate: now, Seconds: 600}
+
+	series, err := OpenRollupSeries(RollupConfig{Resolution: 60, Keep: 3600}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := Website{
+		URL:          testURL,
+		PollResults:  &PollResults{items: results},
+		RawRetention: 5 * time.Minute,
+		Rollups:      []*RollupSeries{series},
+	}
+	expected := w.Aggregate(tf)
+
+	// Apply retention as if it was run just after the end of the timeframe
+	w.ApplyRetention(now.Add(RollupDelay))
+	if raw := w.PollResults.Extract(tf); len(raw) >= len(results) {
+		t.Fatalf("Expected old poll results to expire, got %v poll results", len(raw))
+	}
+	if len(series.items) != 10 {
+		t.Fatalf("Expected 10 rollups, got %v", len(series.items))
+	}
+
+	computed := w.Aggregate(tf)
+	if computed.Availability != expected.Availability || computed.All.Average != expected.All.Average || computed.All.Max != expected.All.Max ||
+		computed.Valid.Average != expected.Valid.Average || computed.Invalid.Max != expected.Invalid.Max {
+		t.Errorf("Expected %v, got %v", expected, computed)
+	}
+	if computed.StatusCodeCounts[500] != 10 || computed.StatusCodeCounts[200] != 50 {
+		t.Errorf("Unexpected response code counts: %v", computed.StatusCodeCounts)
+	}
+
+	// Percentiles cannot be computed from rollups, so they should be left empty
+	// rather than only covering the raw poll results
+	if expected.All.P50 == (payload.Timing{}) || computed.All.P50 != (payload.Timing{}) || computed.All.P99 != (payload.Timing{}) {
+		t.Errorf("Expected percentiles to be left empty, got p50 %v and p99 %v", computed.All.P50, computed.All.P99)
+	}
+}
+
+// Test of rollups coarser than the raw retention
+//
+// Poll results are kept for 5 minutes only, while the coarsest rollups
+// summarize 10 minutes: they should be built from the 1-minute rollups,
+// and account for all the poll results of their bucket.
+func TestRetentionCoarseRollups(t *testing.T) {
+	now := time.Now().Truncate(10 * time.Minute)
+	start := now.Add(-10 * time.Minute)
+	var results []PollResult
+	for d := time.Duration(0); d < 10*time.Minute; d += 10 * time.Second {
+		results = append(results, PollResult{Date: start.Add(d), StatusCode: 200})
+	}
+
+	fine, _ := OpenRollupSeries(RollupConfig{Resolution: 60, Keep: 3600}, "")
+	coarse, _ := OpenRollupSeries(RollupConfig{Resolution: 600}, "")
+	w := Website{
+		URL:          testURL,
+		PollResults:  &PollResults{},
+		RawRetention: 5 * time.Minute,
+		Rollups:      []*RollupSeries{fine, coarse},
+	}
+
+	// Apply retention every minute, while poll results are received
+	for i, p := range results {
+		w.PollResults.Save(p, 0)
+		if (i+1)%6 == 0 {
+			w.ApplyRetention(p.Date.Add(10*time.Second + RollupDelay))
+		}
+	}
+	if raw := w.PollResults.Extract(payload.Timeframe{EndDate: now}); len(raw) >= len(results) {
+		t.Fatalf("Expected old poll results to expire, got %v poll results", len(raw))
+	}
+	if len(coarse.items) != 1 || coarse.items[0].Count != len(results) || !coarse.items[0].Date.Equal(start) {
+		t.Errorf("Expected a single rollup of %v poll results, got %+v", len(results), coarse.items)
+	}
+}
+
+// Test of the validation of retention settings
+func TestCheckRetention(t *testing.T) {
+	series := func(resolution, keep int) *RollupSeries {
+		s, _ := OpenRollupSeries(RollupConfig{Resolution: resolution, Keep: keep}, "")
+		return s
+	}
+
+	// Create table of test cases
+	testCases := []struct {
+		website Website
+		valid   bool
+	}{
+		{Website{Interval: 2, RetainedResults: 1000, RawRetention: time.Hour, Rollups: []*RollupSeries{series(60, 0), series(3600, 86400)}}, true},
+		{Website{Interval: 2, RetainedResults: 1000, RawRetention: time.Minute, Rollups: []*RollupSeries{series(60, 0)}}, false}, // Raw retention too short
+		{Website{Interval: 2, RetainedResults: 10, Rollups: []*RollupSeries{series(60, 0)}}, false},                              // Too few retained results
+		{Website{Interval: 2, Rollups: []*RollupSeries{series(60, 600), series(3600, 0)}}, false},                                // Finer rollups kept too briefly
+		{Website{Interval: 2, Rollups: []*RollupSeries{series(60, 0), series(90, 0)}}, false},                                    // Not a multiple
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			if err := tc.website.CheckRetention(); (err == nil) != tc.valid {
+				t.Errorf("Expected valid to be %v, got error %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+// Test of rollup persistence and expiration
+func TestRollupSeriesPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rollups.log")
+	c := RollupConfig{Resolution: 60, Keep: 600}
+	s, err := OpenRollupSeries(c, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Minute)
+	for i := 20; i > 0; i-- {
+		date := now.Add(-time.Duration(i) * time.Minute)
+		s.add(NewRollup(date, []PollResult{{Date: date, StatusCode: 200}}, nil))
+	}
+	if err = s.Expire(now); err != nil {
+		t.Fatal(err)
+	}
+	s.file.Close()
+
+	// Reload the rollups from disk
+	if s, err = OpenRollupSeries(c, path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.file.Close()
+	if err = s.Expire(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.items) != 10 {
+		t.Fatalf("Expected 10 rollups, got %v", len(s.items))
+	}
+	if r := s.items[0]; r.Count != 1 || r.StatusCodeCounts[200] != 1 || !r.Date.Equal(now.Add(-10*time.Minute)) {
+		t.Errorf("Unexpected rollup: %+v", r)
+	}
+}
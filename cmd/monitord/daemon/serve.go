@@ -13,13 +13,17 @@ import (
 	"net/rpc"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/anatolebeuzon/monitor/internal/payload"
 )
 
 // Handler contains all the necessary data to satisfy RPC calls.
 // It will be registered as the RPC receiver and its methods will be published.
-type Handler Websites
+type Handler struct {
+	Websites Websites
+	History  *AlertHistory // Alerts generated by the AlertEngine
+}
 
 // Stats puts the latest websites stats (aggregated over
 // the specified timespan in seconds) as the reply value.
@@ -27,37 +31,33 @@ type Handler Websites
 // Stats is meant to be used through an RPC call.
 func (h *Handler) Stats(tf payload.Timeframe, p *payload.Stats) error {
 	*p = payload.Stats{Timeframe: tf, Metrics: make(map[string]payload.Metric)}
-	for _, website := range *h {
+	for _, website := range h.Websites {
 		(*p).Metrics[website.URL] = website.Aggregate(tf)
 	}
 	return nil
 }
 
-// Alerts puts the latest websites alerts as the reply value.
+// Alerts puts the alerts recorded during the specified timeframe as the reply value.
 //
-// For each website, it compares its availability
-// (on average, over the specified timespan in seconds)
-// against the threshold, and creates an alert if the threshold is crossed.
+// Alerts are generated by the AlertEngine, independently of RPC calls.
+// Reading them has no side effect, so that multiple clients
+// can be connected at the same time.
 //
 // Alerts is meant to be used through an RPC call.
 func (h *Handler) Alerts(tf payload.Timeframe, a *payload.Alerts) error {
-	*a = make(payload.Alerts)
-	for i, website := range *h {
-		// Get average availability
-		avail := Availability(website.PollResults.Extract(tf))
+	*a = h.History.Extract(tf)
+	return nil
+}
 
-		if (avail < website.Threshold) && !website.DownAlertSent {
-			// if the website is considered down but no alert for this event was sent yet
-			// create a "website is down" alert
-			(*a)[website.URL] = payload.Alert{Timeframe: tf, Availability: avail, BelowThreshold: true}
-			(*h)[i].DownAlertSent = true
-		} else if (avail >= website.Threshold) && website.DownAlertSent {
-			// if the website is considered up but website was last reported down
-			// create a "website has recovered" alert
-			(*a)[website.URL] = payload.Alert{Timeframe: tf, Availability: avail, BelowThreshold: false}
-			(*h)[i].DownAlertSent = false
-		}
-	}
+// AlertHistory puts the incidents matching the query as the reply value.
+//
+// Contrary to Alerts, which returns individual alerts, AlertHistory returns
+// incidents, i.e. periods during which a website was down, with their
+// start and end dates and their duration.
+//
+// AlertHistory is meant to be used through an RPC call.
+func (h *Handler) AlertHistory(q payload.HistoryQuery, i *payload.Incidents) error {
+	*i = h.History.Incidents(q, time.Now())
 	return nil
 }
 
@@ -0,0 +1,270 @@
+/*
+This file contains the on-disk storage of poll results, namely:
+- how poll results are appended to segment files
+- how segment files are deleted according to the retention policy
+- how poll results are reloaded on daemon startup
+*/
+
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SegmentStore is a ResultStore that persists poll results in append-only
+// segment files, in addition to keeping them in memory for fast aggregation.
+//
+// Each poll result is written as a JSON document on its own line, and flushed
+// to disk before Save returns. A line that was partially written when the
+// daemon crashed is discarded when the segments are reloaded.
+//
+// A new segment is started every SegmentSize poll results, and the oldest
+// segments are deleted once they only contain poll results that are not retained
+// or that have expired.
+type SegmentStore struct {
+	PollResults        // In-memory copy of the retained poll results
+	Dir         string // Directory containing the segment files
+	SegmentSize int    // Maximum number of poll results in a segment
+
+	mu       sync.Mutex // Protects the fields below
+	segments []Segment  // Segment files, sorted by increasing date
+	file     *os.File   // Last segment, opened for appending
+}
+
+// A Segment is a file containing consecutive poll results.
+type Segment struct {
+	Path  string
+	Count int       // Number of poll results in the segment
+	Last  time.Time // Date of the last poll result of the segment
+}
+
+// OpenSegmentStore loads the poll results persisted in the segment files of dir,
+// keeping only the latest retained ones (or all of them if retained is 0).
+//
+// If dir does not exist, it is created.
+func OpenSegmentStore(dir string, segmentSize int, retained int) (*SegmentStore, error) {
+	if segmentSize == 0 {
+		segmentSize = 1000
+	}
+	s := &SegmentStore{Dir: dir, SegmentSize: segmentSize}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths) // Segment names start with a fixed-width date
+
+	for i, path := range paths {
+		results, err := ReadSegment(path, i == len(paths)-1)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) == 0 {
+			os.Remove(path)
+			continue
+		}
+		s.items = append(s.items, results...)
+		s.segments = append(s.segments, Segment{path, len(results), results[len(results)-1].Date})
+	}
+
+	// Apply the retention policy
+	if (retained != 0) && (len(s.items) > retained) {
+		s.items = s.items[len(s.items)-retained:]
+	}
+	if err := s.compact(retained); err != nil {
+		return nil, err
+	}
+
+	// Reopen the last segment, if it is not full
+	if n := len(s.segments); n != 0 && s.segments[n-1].Count < s.SegmentSize {
+		if s.file, err = os.OpenFile(s.segments[n-1].Path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ReadSegment returns the poll results contained in a segment file.
+//
+// If truncate is true, the file is truncated after its last valid line,
+// so that new poll results are not appended to a partially written line.
+func ReadSegment(path string, truncate bool) (results []PollResult, err error) {
+	err = ReadJSONLines(path, truncate, func(line []byte) error {
+		var p PollResult
+		if err := json.Unmarshal(line, &p); err != nil {
+			return err
+		}
+		results = append(results, p)
+		return nil
+	})
+	return
+}
+
+// ReadJSONLines reads a file containing one JSON document per line,
+// and calls decode for each line. Lines that cannot be decoded are skipped.
+//
+// If truncate is true, the file is truncated after its last valid line,
+// so that new lines are not appended to a partially written line.
+// If this line is valid but lacks its newline, the newline is added instead.
+func ReadJSONLines(path string, truncate bool, decode func(line []byte) error) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	valid := 0 // Offset of the end of the last valid line
+	offset := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		offset += len(scanner.Bytes()) + 1
+		if err := decode(scanner.Bytes()); err != nil {
+			fmt.Println("Skipping invalid line in", path, ":", err)
+			continue
+		}
+		valid = offset
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	switch {
+	case !truncate:
+		return nil
+	case valid < len(data):
+		return os.Truncate(path, int64(valid))
+	case valid > len(data): // The last line is valid, but was not terminated
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err = f.Write([]byte("\n")); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return nil
+}
+
+// Save appends a PollResult to the last segment, flushes it to disk,
+// and then saves it in memory.
+//
+// If retained is not 0 and the number of poll results exceeds it,
+// the oldest items are deleted from memory, and the segments that only
+// contain deleted items are removed from disk.
+func (s *SegmentStore) Save(p PollResult, retained int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Start a new segment if the last one is full
+	if s.file == nil || s.segments[len(s.segments)-1].Count >= s.SegmentSize {
+		if err := s.rotate(p.Date); err != nil {
+			return err
+		}
+	}
+
+	// Write the poll result on its own line
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if _, err = s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err = s.file.Sync(); err != nil {
+		return err
+	}
+	last := &s.segments[len(s.segments)-1]
+	last.Count++
+	last.Last = p.Date
+
+	if err = s.compact(retained); err != nil {
+		return err
+	}
+	return s.PollResults.Save(p, retained)
+}
+
+// rotate closes the last segment and creates a new one,
+// named after the date of its first poll result.
+func (s *SegmentStore) rotate(first time.Time) (err error) {
+	if s.file != nil {
+		if err = s.file.Close(); err != nil {
+			return
+		}
+	}
+	path := filepath.Join(s.Dir, fmt.Sprintf("%020d.seg", first.UnixNano()))
+	if s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		return
+	}
+	s.segments = append(s.segments, Segment{Path: path})
+	return
+}
+
+// compact deletes the oldest segments, as long as the remaining
+// segments contain at least retained poll results.
+// If retained is 0, no segment is ever deleted.
+func (s *SegmentStore) compact(retained int) error {
+	if retained == 0 {
+		return nil
+	}
+	total := 0
+	for _, seg := range s.segments {
+		total += seg.Count
+	}
+	for len(s.segments) > 1 && total-s.segments[0].Count >= retained {
+		if err := os.Remove(s.segments[0].Path); err != nil {
+			return err
+		}
+		total -= s.segments[0].Count
+		s.segments = s.segments[1:]
+	}
+	return nil
+}
+
+// Expire deletes the poll results that are older than the provided date
+// from memory, and removes the segments that only contain such poll results.
+func (s *SegmentStore) Expire(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.segments) != 0 && s.segments[0].Last.Before(before) {
+		if len(s.segments) == 1 && s.file != nil {
+			// The last segment will be recreated on the next save
+			if err := s.file.Close(); err != nil {
+				return err
+			}
+			s.file = nil
+		}
+		if err := os.Remove(s.segments[0].Path); err != nil {
+			return err
+		}
+		s.segments = s.segments[1:]
+	}
+	return s.PollResults.Expire(before)
+}
+
+// Close closes the last segment file.
+func (s *SegmentStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,125 @@
+/*
+This file contains tests for the on-disk storage of poll results.
+*/
+
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Test of poll results persistence
+//
+// It saves poll results, reloads them as if the daemon had restarted,
+// and checks that the retention policy is applied on disk.
+func TestSegmentStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSegmentStore(dir, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Minute)
+	for i := 0; i < 7; i++ {
+		p := PollResult{Date: start.Add(time.Duration(i) * time.Second), StatusCode: 200 + i}
+		if i == 6 {
+			p = PollResult{Date: p.Date, Error: errors.New("dial tcp: i/o timeout")}
+		}
+		if err := s.Save(p, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	// Only the segments containing retained poll results should be kept
+	// (i.e. [4, 5] and [6])
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(segments) != 2 {
+		t.Errorf("Expected 2 segments on disk, got %v", segments)
+	}
+
+	// Reload the poll results from disk
+	s, err = OpenSegmentStore(dir, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	results := s.Extract(payload.Timeframe{StartDate: start, EndDate: time.Now()})
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 poll results, got %v", results)
+	}
+	if results[0].StatusCode != 204 || results[1].StatusCode != 205 {
+		t.Errorf("Expected the latest poll results to be retained, got %v", results)
+	}
+	if results[2].Error == nil || results[2].Error.Error() != "dial tcp: i/o timeout" {
+		t.Errorf("Expected error to be reloaded, got %v", results[2].Error)
+	}
+	if !results[0].Date.Equal(start.Add(4 * time.Second)) {
+		t.Errorf("Expected date %v, got %v", start.Add(4*time.Second), results[0].Date)
+	}
+}
+
+// Test of crash recovery
+//
+// The last segment ends with a line that was written without its newline:
+// it should be discarded if it is incomplete, and kept otherwise, without
+// preventing new poll results from being saved.
+func TestSegmentStoreCrashRecovery(t *testing.T) {
+	date := time.Now().Add(-time.Minute)
+
+	// Create table of test cases
+	testCases := []struct {
+		line     string // Line written without its newline
+		expected []int  // Expected status codes after recovery
+	}{
+		{`{"Date":"2018-03-01T12:00:00Z","Stat`, []int{200, 500}},
+		{`{"Date":"` + date.Add(time.Second).Format(time.RFC3339Nano) + `","StatusCode":404}`, []int{200, 404, 500}},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := OpenSegmentStore(dir, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Save(PollResult{Date: date, StatusCode: 200}, 0)
+			s.Close()
+
+			// Simulate a crash in the middle of a write
+			segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+			f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(tc.line)
+			f.Close()
+
+			// Reload, save a new poll result, and reload again
+			if s, err = OpenSegmentStore(dir, 10, 0); err != nil {
+				t.Fatal(err)
+			}
+			s.Save(PollResult{Date: date.Add(2 * time.Second), StatusCode: 500}, 0)
+			s.Close()
+			if s, err = OpenSegmentStore(dir, 10, 0); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			var computed []int
+			for _, r := range s.Extract(payload.NewTimeframe(3600)) {
+				computed = append(computed, r.StatusCode)
+			}
+			if fmt.Sprint(computed) != fmt.Sprint(tc.expected) {
+				t.Errorf("Expected status codes %v, got %v", tc.expected, computed)
+			}
+		})
+	}
+}
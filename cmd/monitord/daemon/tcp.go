@@ -0,0 +1,194 @@
+/*
+This file contains the TCP check logic, namely:
+- how a TCP connection is opened to a host and port
+- how a payload is sent and the response is matched against a pattern
+- how the corresponding times are collected
+*/
+
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// MaxTCPResponse is the maximum number of bytes read from a TCP connection
+// while waiting for the expected response.
+const MaxTCPResponse = 64 * 1024
+
+func init() {
+	RegisterChecker("tcp", NewTCPChecker)
+}
+
+// TCPChecker checks a raw TCP service.
+type TCPChecker struct {
+	Address    string         // host:port address of the service
+	Body       []byte         // Payload sent once connected
+	Expect     *regexp.Regexp // Pattern that the response must match. If nil, the response is read until it satisfies the assertions or the connection is closed
+	Assertions []Assertion    // Conditions that the response must satisfy
+	Timeouts   Timeouts       // Timeouts of the check. Only Connect and Overall are used
+}
+
+// NewTCPChecker creates a TCPChecker from the configuration of a website.
+func NewTCPChecker(c WebsiteConfig) (Checker, error) {
+	t := &TCPChecker{Timeouts: NewTimeouts(c.Timeouts)}
+	var err error
+	if t.Address, err = TCPAddress(c.URL); err != nil {
+		return nil, err
+	}
+	if t.Body, err = ReadBody(c); err != nil {
+		return nil, err
+	}
+	if t.Expect, err = CompileExpect(c.Expect); err != nil {
+		return nil, err
+	}
+	if t.Assertions, err = NewAssertions(c.Assertions); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// TCPAddress returns the host:port address of a TCP check URL, e.g. "tcp://db.internal:5432".
+func TCPAddress(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "tcp" || u.Hostname() == "" || u.Port() == "" {
+		return "", fmt.Errorf("TCP checks require a tcp://host:port URL, got %v", rawURL)
+	}
+	return u.Host, nil
+}
+
+// Check opens a TCP connection to the service, sends its payload (if any),
+// and reads the response if an expected pattern or assertions are set:
+// until it matches the pattern (or, if there is no pattern, satisfies the
+// assertions), or until the service closes the connection.
+//
+// The times are recorded in the same payload.Timing structure as HTTP requests:
+// DNS and TCP are the lookup and connection times, Server is the time between
+// the end of the payload and the first response byte, and Transfer is the time
+// until the response matches the pattern.
+func (c *TCPChecker) Check() (p PollResult) {
+	var t [7]time.Time // Same steps as HTTP requests (t[3] to t[4] is an empty TLS step)
+	defer func() {
+		// If an error occured, the times of the remaining steps are set to the last reached one
+		for i := range t {
+			if (i > 0) && t[i].IsZero() {
+				t[i] = t[i-1]
+			}
+		}
+		p.Date = t[0]
+		p.Timing = payload.Timing{
+			DNS:      t[1].Sub(t[0]),
+			TCP:      t[3].Sub(t[2]),
+			Server:   t[5].Sub(t[4]),
+			Transfer: t[6].Sub(t[5]),
+			TTFB:     t[5].Sub(t[0]),
+			Response: t[6].Sub(t[0]),
+		}
+	}()
+
+	// Resolve the host name
+	t[0] = time.Now()
+	host, port, err := net.SplitHostPort(c.Address)
+	if err != nil {
+		p.Error = err
+		return
+	}
+	ctx := context.Background()
+	var deadline time.Time // Zero if there is no overall timeout
+	if c.Timeouts.Overall != 0 {
+		deadline = t[0].Add(c.Timeouts.Overall)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		p.Error = c.Timeouts.Classify(err, TimeoutOverall)
+		return
+	}
+	t[1] = time.Now()
+
+	// Connect to the first reachable address
+	t[2] = time.Now()
+	var conn net.Conn
+	dialer := net.Dialer{Timeout: c.Timeouts.Connect}
+	for _, addr := range addrs {
+		if conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr, port)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		p.Error = c.Timeouts.ClassifyDial(err, deadline)
+		return
+	}
+	defer conn.Close()
+	t[3] = time.Now()
+	t[4] = t[3]
+	conn.SetDeadline(deadline)
+
+	// Send the payload
+	if len(c.Body) != 0 {
+		if _, err = conn.Write(c.Body); err != nil {
+			p.Error = c.Timeouts.Classify(err, TimeoutOverall)
+			return
+		}
+		t[4] = time.Now()
+	}
+	if c.Expect == nil && len(c.Assertions) == 0 {
+		t[5], t[6] = t[4], t[4]
+		return
+	}
+
+	// Read until the response matches the expected pattern, or satisfies
+	// the assertions if there is no pattern, since services such as SMTP
+	// servers keep the connection open. Otherwise, read until the connection
+	// is closed.
+	var resp []byte
+	buf := make([]byte, 4096)
+	for c.Expect == nil || !c.Expect.Match(resp) {
+		n, err := conn.Read(buf)
+		if n != 0 && t[5].IsZero() {
+			t[5] = time.Now()
+		}
+		resp = append(resp, buf[:n]...)
+		if c.Expect == nil && n != 0 && CheckBody(c.Assertions, resp) == nil {
+			break
+		}
+		if err != nil && err != io.EOF {
+			p.Error = c.Timeouts.Classify(err, TimeoutOverall)
+			return
+		}
+		if err == io.EOF || len(resp) >= MaxTCPResponse {
+			if c.Expect != nil && !c.Expect.Match(resp) {
+				p.Error = fmt.Errorf("response does not match %q", c.Expect.String())
+				return
+			}
+			break
+		}
+	}
+	if t[5].IsZero() {
+		t[5] = time.Now() // The connection was closed without any response
+	}
+	t[6] = time.Now()
+	p.Error = CheckBody(c.Assertions, resp)
+	return
+}
+
+// CompileExpect compiles the expected response pattern of a TCP check.
+// It returns nil if the pattern is empty.
+func CompileExpect(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
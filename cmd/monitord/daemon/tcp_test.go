@@ -0,0 +1,114 @@
+/*
+This file contains tests for the TCP check logic.
+*/
+
+package daemon
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test of TCP checks
+//
+// The server replies "+PONG" to "PING", sends a greeting and closes the connection
+// on "HELLO", sends a banner and keeps the connection open on "BANNER", does
+// not reply to "WAIT", and closes the connection otherwise.
+func TestTCPCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				switch {
+				case err != nil:
+				case line == "PING\r\n":
+					conn.Write([]byte("+PONG\r\n"))
+				case line == "HELLO\r\n":
+					conn.Write([]byte("Hello, world\r\n"))
+				case line == "BANNER\r\n":
+					conn.Write([]byte("220 ready\r\n"))
+					time.Sleep(time.Second)
+				case line == "WAIT\r\n":
+					time.Sleep(time.Second)
+				}
+			}(conn)
+		}
+	}()
+
+	// Get the address of a closed port
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	// Create table of test cases
+	testCases := []struct {
+		address  string
+		send     string
+		expect   string
+		contains string // Assertion on the response
+		expected string // Expected error, or "" if the check should succeed
+	}{
+		{l.Addr().String(), "", "", "", ""},                                                  // Connection only
+		{l.Addr().String(), "PING\r\n", `^\+PONG`, "", ""},                                   // Expected response
+		{l.Addr().String(), "QUIT\r\n", `^\+PONG`, "", `response does not match "^\\+PONG"`}, // Connection closed without the expected response
+		{"localhost:" + portOf(l), "PING\r\n", "PONG", "", ""},                               // Host name resolution
+		{l.Addr().String(), "HELLO\r\n", "", "world", ""},                                    // Assertion without pattern
+		{l.Addr().String(), "HELLO\r\n", "", "PONG", `body does not contain "PONG"`},         // Failed assertion without pattern
+		{l.Addr().String(), "BANNER\r\n", "", "220", ""},                                     // Assertion without pattern, connection kept open
+		{l.Addr().String(), "WAIT\r\n", `^\+PONG`, "", "overall timeout (100ms)"},            // No response
+		{closedAddr, "", "", "", "connection refused"},                                       // Connection refused
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			c := TCPChecker{Address: tc.address, Body: []byte(tc.send), Timeouts: Timeouts{Overall: 100 * time.Millisecond}}
+			if tc.expect != "" {
+				c.Expect = regexp.MustCompile(tc.expect)
+			}
+			if tc.contains != "" {
+				c.Assertions, _ = NewAssertions([]AssertionConfig{{Contains: tc.contains}})
+			}
+			p := c.Check()
+			if actual := fmt.Sprint(p.Error); (tc.expected == "" && p.Error != nil) || (tc.expected != "" && !strings.Contains(actual, tc.expected)) {
+				t.Errorf("Expected error %q, got %q", tc.expected, actual)
+			}
+			if p.Date.IsZero() || p.Timing.Response < p.Timing.TTFB || p.Timing.TTFB < p.Timing.TCP {
+				t.Errorf("Inconsistent timings: %+v", p.Timing)
+			}
+		})
+	}
+}
+
+// Test of TCP check URLs
+func TestTCPAddress(t *testing.T) {
+	if addr, err := TCPAddress("tcp://db.internal:5432"); err != nil || addr != "db.internal:5432" {
+		t.Errorf("Expected db.internal:5432, got %v (%v)", addr, err)
+	}
+	for _, u := range []string{"http://db.internal:5432", "tcp://db.internal", "db.internal:5432"} {
+		if _, err := TCPAddress(u); err == nil {
+			t.Errorf("Expected an error for %v, got nil", u)
+		}
+	}
+}
+
+// portOf is a helper function that returns the port of a listener.
+func portOf(l net.Listener) string {
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
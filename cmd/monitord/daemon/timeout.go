@@ -0,0 +1,199 @@
+/*
+This file contains the timeout logic, namely:
+- how the timeouts of a website are built from the config file
+- how requests are cancelled when their overall or body timeout expires
+- how timeout errors are classified by phase
+*/
+
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Phases of a request, used to classify timeout errors.
+const (
+	TimeoutOverall = "overall"
+	TimeoutConnect = "connect"
+	TimeoutTLS     = "TLS"
+	TimeoutHeader  = "header"
+	TimeoutBody    = "body"
+)
+
+// DefaultTimeouts are the timeouts used when they are not set in the config file.
+// They are purposefully low, to allow for quick error detection and alerting.
+var DefaultTimeouts = Timeouts{
+	Overall: 10 * time.Second,
+	Connect: 4 * time.Second,
+	TLS:     4 * time.Second,
+}
+
+// Timeouts are the timeouts of the requests made to a website.
+// A timeout of 0 means that there is no limit for this phase, besides Overall.
+type Timeouts struct {
+	Overall time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	Header  time.Duration
+	Body    time.Duration
+}
+
+// WithDefaults returns the timeout configuration, falling back to
+// the default configuration for the timeouts that are not filled.
+func (c TimeoutConfig) WithDefaults(def TimeoutConfig) TimeoutConfig {
+	if c.Overall == 0 {
+		c.Overall = def.Overall
+	}
+	if c.Connect == 0 {
+		c.Connect = def.Connect
+	}
+	if c.TLS == 0 {
+		c.TLS = def.TLS
+	}
+	if c.Header == 0 {
+		c.Header = def.Header
+	}
+	if c.Body == 0 {
+		c.Body = def.Body
+	}
+	return c
+}
+
+// NewTimeouts creates Timeouts from their configuration,
+// using DefaultTimeouts for the timeouts that are not filled.
+func NewTimeouts(c TimeoutConfig) Timeouts {
+	ms := func(v int, def time.Duration) time.Duration {
+		if v == 0 {
+			return def
+		}
+		return time.Duration(v) * time.Millisecond
+	}
+	return Timeouts{
+		Overall: ms(c.Overall, DefaultTimeouts.Overall),
+		Connect: ms(c.Connect, DefaultTimeouts.Connect),
+		TLS:     ms(c.TLS, DefaultTimeouts.TLS),
+		Header:  ms(c.Header, DefaultTimeouts.Header),
+		Body:    ms(c.Body, DefaultTimeouts.Body),
+	}
+}
+
+// A TimeoutError is the error of a request that exceeded one of its timeouts.
+//
+// As its message only depends on the phase and timeout, timeouts are counted
+// separately for each phase in the error counts of the dashboard.
+type TimeoutError struct {
+	Phase string        // Phase of the request whose timeout was exceeded, e.g. TimeoutConnect
+	Limit time.Duration // Timeout that was exceeded
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%v timeout (%v)", e.Phase, e.Limit)
+}
+
+// Timeout implements the net.Error interface.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Temporary implements the net.Error interface.
+func (e *TimeoutError) Temporary() bool { return true }
+
+// IsTimeout returns whether err is a network timeout.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// An Expiry cancels a request once one of its timeouts expires,
+// and records which timeout did.
+type Expiry struct {
+	Context context.Context // Context of the request, cancelled when a timeout expires
+	Cancel  context.CancelFunc
+
+	mu  sync.Mutex    // Protects err
+	err *TimeoutError // First timeout that expired, or nil
+}
+
+// NewExpiry creates an Expiry whose context is derived from parent.
+// Its Cancel function must be called once the request is over.
+func NewExpiry(parent context.Context) *Expiry {
+	x := &Expiry{}
+	x.Context, x.Cancel = context.WithCancel(parent)
+	return x
+}
+
+// Start starts a timer that cancels the request after d, unless
+// it is stopped before. If d is 0, no timer is started.
+func (x *Expiry) Start(phase string, d time.Duration) (stop func() bool) {
+	if d == 0 {
+		return func() bool { return false }
+	}
+	return time.AfterFunc(d, func() {
+		x.mu.Lock()
+		if x.err == nil {
+			x.err = &TimeoutError{phase, d}
+		}
+		x.mu.Unlock()
+		x.Cancel()
+	}).Stop
+}
+
+// Err returns the TimeoutError of the timeout that cancelled the request, or nil.
+func (x *Expiry) Err() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.err == nil {
+		return nil
+	}
+	return x.err
+}
+
+// Classify returns a TimeoutError for the phase if err is a timeout, or err otherwise.
+// It is used by checks that are not traced as HTTP requests.
+func (t Timeouts) Classify(err error, phase string) error {
+	if !IsTimeout(err) {
+		return err
+	}
+	switch phase {
+	case TimeoutConnect:
+		return &TimeoutError{phase, t.Connect}
+	case TimeoutTLS:
+		return &TimeoutError{phase, t.TLS}
+	case TimeoutHeader:
+		return &TimeoutError{phase, t.Header}
+	case TimeoutBody:
+		return &TimeoutError{phase, t.Body}
+	}
+	return &TimeoutError{phase, t.Overall}
+}
+
+// ClassifyDial classifies the error of a connection attempt, which is limited by
+// both the connect timeout and the overall deadline (which is zero if there is none).
+func (t Timeouts) ClassifyDial(err error, deadline time.Time) error {
+	if deadline.IsZero() || time.Now().Before(deadline) {
+		return t.Classify(err, TimeoutConnect)
+	}
+	return t.Classify(err, TimeoutOverall)
+}
+
+// Classify returns a TimeoutError for the phase that the traced request
+// had reached if err is a timeout of the transport, or err otherwise.
+func (r *RequestTrace) Classify(err error, t Timeouts) error {
+	if !IsTimeout(err) {
+		return err
+	}
+	if _, ok := err.(*TimeoutError); ok {
+		return err
+	}
+	switch {
+	case !r.connected:
+		return &TimeoutError{TimeoutConnect, t.Connect}
+	case !r.gotConn:
+		return &TimeoutError{TimeoutTLS, t.TLS}
+	default:
+		return &TimeoutError{TimeoutHeader, t.Header}
+	}
+}
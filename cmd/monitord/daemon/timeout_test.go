@@ -0,0 +1,105 @@
+/*
+This file contains tests for the timeout logic.
+*/
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test of the timeouts of HTTP checks
+//
+// /slow-header waits before sending the response headers, and /slow-body
+// sends the headers right away but waits before sending the body.
+// The TLS listener accepts connections but never completes the handshake.
+func TestTimeouts(t *testing.T) {
+	wait := func(r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/slow-header", func(w http.ResponseWriter, r *http.Request) { wait(r) })
+	mux.HandleFunc("/slow-body", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		wait(r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	// Create table of test cases
+	testCases := []struct {
+		url      string
+		timeouts TimeoutConfig
+		expected string // Expected error, or "" if the check should succeed
+	}{
+		{server.URL + "/fast", TimeoutConfig{Overall: 200}, ""},
+		{server.URL + "/slow-header", TimeoutConfig{Overall: 200}, "overall timeout (200ms)"},
+		{server.URL + "/slow-header", TimeoutConfig{Header: 100}, "header timeout (100ms)"},
+		{server.URL + "/slow-body", TimeoutConfig{Header: 100, Body: 100}, "body timeout (100ms)"},
+		{server.URL + "/slow-body", TimeoutConfig{Overall: 200, Body: 500}, "overall timeout (200ms)"},
+		{"https://" + listener.Addr().String(), TimeoutConfig{TLS: 100}, "TLS timeout (100ms)"},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			checker, err := NewHTTPChecker(WebsiteConfig{URL: tc.url, Timeouts: tc.timeouts})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := checker.Check()
+			if actual := fmt.Sprint(p.Error); (tc.expected == "" && p.Error != nil) || (tc.expected != "" && actual != tc.expected) {
+				t.Errorf("Check: expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+// Test of the timeouts defaulting to the ones of the Default config, then to DefaultTimeouts
+func TestNewTimeouts(t *testing.T) {
+	def := TimeoutConfig{Overall: 30000, Body: 1000}
+
+	// Create table of test cases
+	testCases := []struct {
+		config   TimeoutConfig
+		expected Timeouts
+	}{
+		{TimeoutConfig{}, Timeouts{Overall: 30 * time.Second, Connect: 4 * time.Second, TLS: 4 * time.Second, Body: time.Second}},
+		{TimeoutConfig{Overall: 500, Header: 200}, Timeouts{Overall: 500 * time.Millisecond, Connect: 4 * time.Second, TLS: 4 * time.Second, Header: 200 * time.Millisecond, Body: time.Second}},
+		{TimeoutConfig{Connect: 100, TLS: 300, Body: 2000}, Timeouts{Overall: 30 * time.Second, Connect: 100 * time.Millisecond, TLS: 300 * time.Millisecond, Body: 2 * time.Second}},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			if actual := NewTimeouts(tc.config.WithDefaults(def)); actual != tc.expected {
+				t.Errorf("NewTimeouts: expected %+v, got %+v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+/*
+This file contains the TLS settings logic, namely:
+- how custom CA bundles and client certificates are loaded
+- how the TLS configuration of a website's transport is built
+*/
+
+package daemon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSVersions maps from the TLS versions accepted in the config file
+// to their crypto/tls identifier.
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// InsecureWarning is the warning shown on the dashboard
+// for websites whose certificate is not verified.
+const InsecureWarning = "TLS certificate verification is disabled"
+
+// TLSWarnings returns the warnings shown on the dashboard for the TLS settings of a website.
+func TLSWarnings(c TLSConfig) (warnings []string) {
+	if c.InsecureSkipVerify {
+		warnings = append(warnings, InsecureWarning)
+	}
+	return
+}
+
+// NewTLSConfig creates the TLS configuration used to poll a website.
+// It returns nil if the default settings should be used.
+//
+// The CA file is trusted in addition to the system roots,
+// so that public and private certificates can both be verified.
+func NewTLSConfig(c TLSConfig) (*tls.Config, error) {
+	if c == (TLSConfig{}) {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if config.RootCAs, err = x509.SystemCertPool(); err != nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %v", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("client certificates require both CertFile and KeyFile")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.MinVersion != "" {
+		v, ok := TLSVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", c.MinVersion)
+		}
+		config.MinVersion = v
+	}
+	return config, nil
+}
@@ -0,0 +1,129 @@
+/*
+This file contains tests for the TLS settings logic.
+*/
+
+package daemon
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anatolebeuzon/monitor/internal/payload"
+)
+
+// Test of polls to a server using a private CA and requiring a client certificate
+func TestPollMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeClientCert(t, dir)
+	clientCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start a server that only accepts the client certificate
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	clients := x509.NewCertPool()
+	clients.AddCert(clientCert.Leaf)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clients}
+	server.StartTLS()
+	defer server.Close()
+
+	// Write the certificate of the server to a CA file
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+
+	// Create table of test cases
+	testCases := []struct {
+		name     string
+		config   TLSConfig
+		expected bool // Whether the poll should succeed
+	}{
+		{"system roots", TLSConfig{}, false},
+		{"no client certificate", TLSConfig{CAFile: caFile}, false},
+		{"CA file and client certificate", TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2"}, true},
+		{"insecure", TLSConfig{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true}, true},
+	}
+
+	// Run tests
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			checker, err := NewChecker(WebsiteConfig{URL: server.URL, TLS: tc.config})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := Website{URL: server.URL, PollResults: &PollResults{}, Checker: checker, Warnings: TLSWarnings(tc.config)}
+			w.Poll()
+			p := w.PollResults.Extract(payload.NewTimeframe(10))
+			if len(p) != 1 {
+				t.Fatalf("Expected 1 poll result, got %v", p)
+			}
+			if succeeded := p[0].Error == nil && p[0].StatusCode == 200; succeeded != tc.expected {
+				t.Errorf("Expected success=%v, got %+v", tc.expected, p[0])
+			}
+			if tc.expected && p[0].Certificate == nil {
+				t.Error("Expected the certificate to be recorded")
+			}
+			if insecure := len(w.Aggregate(payload.NewTimeframe(10)).Warnings) != 0; insecure != tc.config.InsecureSkipVerify {
+				t.Errorf("Expected warning=%v, got %v", tc.config.InsecureSkipVerify, insecure)
+			}
+		})
+	}
+}
+
+// Test of invalid TLS settings, which should be rejected on startup
+func TestInvalidTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeClientCert(t, dir)
+	for _, c := range []TLSConfig{
+		{CAFile: "/nonexistent/ca.pem"},
+		{CAFile: keyFile}, // No certificate in the file
+		{CertFile: certFile},
+		{MinVersion: "2.0"},
+	} {
+		if _, err := NewTLSConfig(c); err == nil {
+			t.Errorf("Expected an error for %+v, got nil", c)
+		}
+	}
+}
+
+// writeClientCert is a helper function that generates a self-signed
+// client certificate, and writes it and its key to PEM files in dir.
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "monitord"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return
+}
@@ -0,0 +1,331 @@
+/*
+This file contains the WebSocket check logic, namely:
+- how the upgrade handshake is performed
+- how a message is sent and the reply is matched against a pattern
+- how WebSocket frames are encoded and decoded
+*/
+
+package daemon
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+func init() {
+	RegisterChecker("websocket", NewWebSocketChecker)
+}
+
+// WebSocketGUID is the value appended to the handshake key to compute the accept key.
+const WebSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxWebSocketMessage is the maximum size of the messages read while waiting for the expected reply.
+const MaxWebSocketMessage = 64 * 1024
+
+// DefaultReplyTimeout is the time during which a reply is awaited, if ReplyTimeout is not set.
+const DefaultReplyTimeout = 4 * time.Second
+
+// WebSocket opcodes, see RFC 6455.
+const (
+	WebSocketContinuation = 0x0
+	WebSocketText         = 0x1
+	WebSocketBinary       = 0x2
+	WebSocketClose        = 0x8
+	WebSocketPing         = 0x9
+	WebSocketPong         = 0xa
+)
+
+// WebSocketChecker checks a WebSocket endpoint.
+type WebSocketChecker struct {
+	URL          string            // URL of the handshake request, with an http or https scheme
+	Headers      map[string]string // Headers of the handshake request
+	Message      []byte            // Message sent once connected. If empty, no message is sent
+	Expect       *regexp.Regexp    // Pattern that the reply must match. If nil, any reply is accepted
+	ReplyTimeout time.Duration     // Time during which the reply is awaited
+	Assertions   []Assertion       // Conditions that the reply must satisfy
+	Auth         Authenticator     // Adds credentials to the handshake request. If nil, it is not authenticated
+	TLSConfig    *tls.Config       // TLS settings of wss:// connections. If nil, the default settings are used
+	Timeouts     Timeouts          // Timeouts of the handshake
+}
+
+// NewWebSocketChecker creates a WebSocketChecker from the configuration of a website.
+// The URL is of the form ws://host[:port]/path or wss://host[:port]/path.
+func NewWebSocketChecker(c WebsiteConfig) (Checker, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return nil, fmt.Errorf("WebSocket checks require a ws:// or wss:// URL, got %v", c.URL)
+	}
+
+	ws := &WebSocketChecker{
+		URL:          u.String(),
+		Headers:      c.Headers,
+		ReplyTimeout: time.Duration(c.ReplyTimeout) * time.Millisecond,
+		Timeouts:     NewTimeouts(c.Timeouts),
+	}
+	if ws.ReplyTimeout == 0 {
+		ws.ReplyTimeout = DefaultReplyTimeout
+	}
+	if ws.Message, err = ReadBody(c); err != nil {
+		return nil, err
+	}
+	if ws.Expect, err = CompileExpect(c.Expect); err != nil {
+		return nil, err
+	}
+	if ws.Assertions, err = NewAssertions(c.Assertions); err != nil {
+		return nil, err
+	}
+	if ws.Auth, err = NewAuthenticator(c.Auth); err != nil {
+		return nil, err
+	}
+	if ws.TLSConfig, err = NewTLSConfig(c.TLS); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// Check performs the upgrade handshake, sends the message (if any),
+// and waits for a reply matching the expected pattern (if a message
+// was sent or a pattern is set).
+//
+// The times are recorded in the same payload.Timing structure as HTTP requests:
+// TTFB is the handshake latency, and Transfer is the time between the end
+// of the handshake and the reply.
+func (ws *WebSocketChecker) Check() (p PollResult) {
+	// Create the handshake request
+	req, key, err := ws.NewRequest()
+	if err == nil && ws.Auth != nil {
+		err = ws.Auth.Authenticate(req)
+	}
+	if err != nil {
+		return PollResult{Date: time.Now(), Error: err}
+	}
+
+	var trace RequestTrace
+	defer trace.Save(&p)
+
+	// Perform the handshake, within the overall timeout
+	x := NewExpiry(req.Context())
+	defer x.Cancel()
+	stop := x.Start(TimeoutOverall, ws.Timeouts.Overall)
+	resp, err := NewTransport(ws.TLSConfig, ws.Timeouts).RoundTrip(trace.Start(req.WithContext(x.Context), ws.TLSConfig))
+	stop()
+	if err != nil {
+		if p.Error = x.Err(); p.Error == nil {
+			p.Error = trace.Classify(err, ws.Timeouts)
+		}
+		return
+	}
+	p.StatusCode = resp.StatusCode
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		p.Error = fmt.Errorf("server did not switch to the WebSocket protocol")
+		return
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != WebSocketAccept(key) {
+		p.Error = errors.New("invalid Sec-WebSocket-Accept header in handshake response")
+		return
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		p.Error = errors.New("WebSocket connection is not writable")
+		return
+	}
+
+	// Send the message
+	if len(ws.Message) != 0 {
+		opcode := byte(WebSocketBinary)
+		if utf8.Valid(ws.Message) {
+			opcode = WebSocketText
+		}
+		if err = WriteWebSocketFrame(conn, opcode, ws.Message, true); err != nil {
+			p.Error = err
+			trace.Done()
+			return
+		}
+	} else if ws.Expect == nil {
+		trace.Done()
+		WriteWebSocketFrame(conn, WebSocketClose, []byte{0x03, 0xe8}, true) // Normal closure
+		return
+	}
+
+	// Wait for the reply. Closing the connection on timeout unblocks the pending read.
+	timer := time.AfterFunc(ws.ReplyTimeout, func() { conn.Close() })
+	reply, err := ws.ReadReply(conn)
+	timer.Stop()
+	trace.Done()
+	if err != nil {
+		if ws.Expect != nil {
+			p.Error = fmt.Errorf("no reply matching %q within %v: %v", ws.Expect.String(), ws.ReplyTimeout, err)
+		} else {
+			p.Error = fmt.Errorf("no reply within %v: %v", ws.ReplyTimeout, err)
+		}
+		return
+	}
+	WriteWebSocketFrame(conn, WebSocketClose, []byte{0x03, 0xe8}, true) // Normal closure
+	p.Error = CheckBody(ws.Assertions, reply)
+	return
+}
+
+// NewRequest creates the handshake request, and returns it along with its key.
+func (ws *WebSocketChecker) NewRequest() (*http.Request, string, error) {
+	req, err := http.NewRequest("GET", ws.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	for k, v := range ws.Headers {
+		if http.CanonicalHeaderKey(k) == "Host" {
+			req.Host = v
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, "", err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	return req, key, nil
+}
+
+// ReadReply reads messages from the connection until one matches the expected pattern
+// (or until the first one if no pattern is set), answering pings along the way.
+func (ws *WebSocketChecker) ReadReply(conn io.ReadWriter) ([]byte, error) {
+	var msg []byte
+	for {
+		opcode, fin, payload, err := ReadWebSocketFrame(conn)
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case WebSocketPing:
+			if err = WriteWebSocketFrame(conn, WebSocketPong, payload, true); err != nil {
+				return nil, err
+			}
+			continue
+		case WebSocketPong:
+			continue
+		case WebSocketClose:
+			return nil, errors.New("connection closed by server")
+		}
+
+		msg = append(msg, payload...)
+		if len(msg) > MaxWebSocketMessage {
+			return nil, errors.New("message is too large")
+		}
+		if !fin {
+			continue // Wait for the continuation frames
+		}
+		if ws.Expect == nil || ws.Expect.Match(msg) {
+			return msg, nil
+		}
+		msg = nil
+	}
+}
+
+// WebSocketAccept returns the accept key expected in response to a handshake key.
+func WebSocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + WebSocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// ReadWebSocketFrame reads a frame, and returns its opcode, FIN bit and unmasked payload.
+func ReadWebSocketFrame(r io.Reader) (opcode byte, fin bool, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > MaxWebSocketMessage {
+		return 0, false, nil, errors.New("message is too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// WriteWebSocketFrame writes a single-frame message. Frames sent by clients must be masked.
+func WriteWebSocketFrame(w io.Writer, opcode byte, payload []byte, mask bool) error {
+	frame := []byte{0x80 | opcode, 0}
+	switch {
+	case len(payload) < 126:
+		frame[1] = byte(len(payload))
+	case len(payload) < 1<<16:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	if !mask {
+		_, err := w.Write(append(frame, payload...))
+		return err
+	}
+	frame[1] |= 0x80
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	frame = append(frame, key[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
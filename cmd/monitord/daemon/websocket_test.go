@@ -0,0 +1,134 @@
+/*
+This file contains tests for the WebSocket check logic.
+*/
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test of WebSocket checks
+//
+// The server replies to "ping" with a ping frame, an unrelated "hello" message,
+// and a "pong" message split in two frames. It refuses to upgrade on /deny,
+// and replies with an invalid accept key on /badkey. A separate listener
+// accepts connections but never answers the handshake.
+func TestWebSocketCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deny" || r.Header.Get("Upgrade") != "websocket" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		accept := WebSocketAccept(r.Header.Get("Sec-WebSocket-Key"))
+		if r.URL.Path == "/badkey" {
+			accept = "invalid"
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n", accept)
+		buf.Flush()
+
+		for {
+			opcode, _, payload, err := ReadWebSocketFrame(buf)
+			if err != nil || opcode == WebSocketClose {
+				return
+			}
+			if opcode == WebSocketText && string(payload) == "ping" {
+				WriteWebSocketFrame(conn, WebSocketPing, nil, false)
+				WriteWebSocketFrame(conn, WebSocketText, []byte("hello"), false)
+				conn.Write([]byte{WebSocketText, 2, 'p', 'o'})                // Not final
+				conn.Write([]byte{0x80 | WebSocketContinuation, 2, 'n', 'g'}) // Final
+			}
+		}
+	}))
+	defer server.Close()
+	wsURL := "ws://" + strings.TrimPrefix(server.URL, "http://")
+
+	// Listener that accepts connections but never answers the handshake
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	// Create table of test cases
+	testCases := []struct {
+		config   WebsiteConfig
+		expected string // Expected error, or "" if the check should succeed
+	}{
+		{WebsiteConfig{URL: wsURL}, ""}, // Handshake only
+		{WebsiteConfig{URL: wsURL, Body: "ping", Expect: "^pong$"}, ""},
+		{WebsiteConfig{URL: wsURL, Body: "ping", Expect: "^pong$", Assertions: []AssertionConfig{{Contains: "ng"}}}, ""},
+		{WebsiteConfig{URL: wsURL, Body: "ping", Expect: "^hello$", Assertions: []AssertionConfig{{Contains: "pong"}}}, `body does not contain "pong"`},
+		{WebsiteConfig{URL: wsURL, Body: "ping", Expect: "^bye$", ReplyTimeout: 100}, `no reply matching "^bye$" within 100ms`},
+		{WebsiteConfig{URL: wsURL + "/deny"}, "server did not switch to the WebSocket protocol"},
+		{WebsiteConfig{URL: wsURL + "/badkey"}, "invalid Sec-WebSocket-Accept header in handshake response"},
+		{WebsiteConfig{URL: "ws://" + silent.Addr().String(), Timeouts: TimeoutConfig{Overall: 300}}, "overall timeout (300ms)"},
+	}
+
+	// Run tests
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			tc.config.Type = "websocket"
+			c, err := NewChecker(tc.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := c.Check()
+			computed := ""
+			if p.Error != nil {
+				computed = p.Error.Error()
+			}
+			if !strings.HasPrefix(computed, tc.expected) || (tc.expected == "") != (computed == "") {
+				t.Errorf("Expected %q, got %q", tc.expected, computed)
+			}
+			if p.Date.IsZero() || p.Timing.TTFB == 0 || p.Timing.Response < p.Timing.TTFB {
+				t.Errorf("Inconsistent timings: %+v", p.Timing)
+			}
+		})
+	}
+}
+
+// Test of the encoding and decoding of WebSocket frames
+func TestWebSocketFrame(t *testing.T) {
+	for i, size := range []int{0, 5, 125, 126, 1000, 70000} {
+		t.Run(fmt.Sprint("Test case ", i), func(t *testing.T) {
+			payload := []byte(strings.Repeat("x", size))
+			for _, mask := range []bool{false, true} {
+				var b strings.Builder
+				if err := WriteWebSocketFrame(&b, WebSocketBinary, payload, mask); err != nil {
+					t.Fatal(err)
+				}
+				opcode, fin, decoded, err := ReadWebSocketFrame(strings.NewReader(b.String()))
+				if size > MaxWebSocketMessage {
+					if err == nil {
+						t.Error("Expected an error for a too large message")
+					}
+					continue
+				}
+				if err != nil || opcode != WebSocketBinary || !fin || string(decoded) != string(payload) {
+					t.Errorf("Unexpected frame: %v %v %v %v", opcode, fin, len(decoded), err)
+				}
+			}
+		})
+	}
+}
@@ -16,21 +16,195 @@ A sample JSON config file is described below:
 
 	{
 		"ListeningPort": 1234, 			// the port on which the RPC server listens
+		"Alerts": {
+			"Interval": 4,				// the interval, in seconds, between two alert checks
+			"Timespan": 120,			// the timespan, in seconds, over which availability is computed
+			"Log": "alerts.log"			// the file in which alerts are persisted (optional)
+		},
+		"Notifications": {
+			"Webhook": {
+				"Timeout": 5,			// the timeout, in seconds, of each webhook request
+				"Retries": 3,			// the number of retries when a webhook request fails
+				"Backoff": 1			// the delay, in seconds, before the first retry (doubled after each retry)
+			},
+			"Email": {					// alerts generated at the same time are sent in a single email
+				"Server": "smtp.example.com:587",
+				"From": "monitor@example.com",
+				"Recipients": ["oncall@example.com"],
+				"StartTLS": true,		// whether to upgrade the connection to TLS using STARTTLS
+				"Username": "monitor",	// credentials used for PLAIN authentication (optional)
+				"Password": "secret"
+			},
+			"Exec": {					// a local command run on each alert
+				"Command": "/usr/local/bin/page",
+				"Args": ["--team", "web"],
+				"Timeout": 10,			// the duration, in seconds, after which the command is killed
+				"Concurrency": 2		// the maximum number of commands running at the same time
+			}
+		},
+		"Storage": {
+			"Path": "/var/lib/monitord",	// the directory in which poll results are persisted (optional)
+			"SegmentSize": 1000			// the number of poll results per segment file
+		},
+		"Histogram": [100, 300, 800],	// the upper bounds, in ms, of the buckets of the response time distribution
+		"Retention": {
+			"Raw": 86400,				// the duration, in seconds, during which raw poll results are kept (optional)
+			"Rollups": [				// summaries of poll results, used to aggregate long timeframes
+				{ "Resolution": 60, "Keep": 2592000 },		// keep 1-minute rollups for 30 days
+				{ "Resolution": 3600, "Keep": 31536000 }	// keep 1-hour rollups for 1 year
+			]
+		},
 		"Default": {
 			"Interval": 2, 				// the interval, in seconds, between two requests to a given website
 			"RetainedResults": 1000, 	// the number of poll results that are retained for a given website
-			"Threshold": 0.8			// the availability threshold that triggers an alert when crossed
+			"Threshold": 0.8,			// the availability threshold that triggers an alert when crossed
+			"CertificateDays": 14,		// the number of days before TLS certificate expiry under which an alert is raised
+			"Webhooks": [],				// the endpoints to which alerts are posted
+			"Retries": 1,				// the number of times a failed poll is retried before being recorded
+			"RetryDelay": 500,			// the delay, in ms, between two attempts
+			"Headers": { "User-Agent": "monitord" },	// the headers sent with each request
+			"Timeouts": {				// timeouts of each request, in ms (0 means the defaults below)
+				"Overall": 10000,			// the whole request, including the body (default: 10000)
+				"Connect": 4000,			// the TCP connection (default: 4000)
+				"TLS": 4000,				// the TLS handshake (default: 4000)
+				"Header": 5000,				// the wait for the response headers (default: no limit)
+				"Body": 5000				// the read of the response body (default: no limit)
+			},
+			"Policy": {					// responses that violate the policy count as unavailable
+				"MaxResponseTime": 3000,	// the maximum response time, in ms
+				"MaxTTFB": 1000,			// the maximum time to first byte, in ms
+				"AllowedStatusCodes": [],	// if not empty, other response codes are violations
+				"ForbiddenStatusCodes": [301, 302]
+			}
 		},
 		"Websites": [					// Websites to poll
 			{
 				"URL": "https://www.datadoghq.com",
 				"Interval": 5,						// Defaults can be overridden on a per-website basis
 				"RetainedResults": 5000,
-				"Threshold": 0.95
+				"Threshold": 0.95,
+				"Webhooks": ["https://hooks.example.com/monitor"],
+				"Policy": { "MaxResponseTime": 8000 },	// each policy criterion can be overridden too
+				"Assertions": [						// conditions that the response body must satisfy
+					{ "Contains": "maintenance", "Negate": true },
+					{ "Regex": "Datadog" },
+					{ "JSONPath": "$.checks[0].status", "Equals": "ok" }	// for JSON bodies
+				]
+			},
+			{
+				"URL": "https://api.example.com/health",
+				"Method": "POST",					// GET by default
+				"Headers": {						// added to the default headers
+					"Content-Type": "application/json",
+					"Host": "health.internal"		// overrides the host of the URL
+				},
+				"Body": "{\"deep\": true}",		// or "BodyFile": "health.json" to read it from a file
+				"Auth": {							// credentials added to each request (optional)
+					"Type": "oauth2",				// "basic", "bearer" or "oauth2"
+					"TokenURL": "https://auth.example.com/token",
+					"ClientID": "monitord",
+					"ClientSecret": { "Env": "MONITORD_CLIENT_SECRET" },	// or { "File": "/run/secrets/client" }
+					"Scopes": ["health"]
+				}
+			},
+			{
+				"URL": "https://intranet.example.com",
+				"Auth": { "Type": "basic", "Username": "monitor", "Password": { "File": "/run/secrets/intranet" } },
+				"TLS": {							// TLS settings of the connections (optional)
+					"CAFile": "/etc/monitord/internal-ca.pem",	// trusted in addition to the system CAs
+					"CertFile": "/etc/monitord/client.pem",		// client certificate for mutual TLS
+					"KeyFile": "/etc/monitord/client.key",
+					"ServerName": "intranet.internal",	// name used to verify the server certificate
+					"MinVersion": "1.2",
+					"InsecureSkipVerify": false		// if true, a warning is shown on the dashboard
+				},
+				"Redirects": {					// by default, redirects are not followed
+					"Max": 3,					// follow up to 3 redirects; or "Forbid": true to reject any redirect
+					"FinalURL": "^https://intranet\\.internal/"	// optional pattern that the final URL must match
+				}
+			},
+			{
+				"URL": "tcp://db.internal:6379",	// TCP checks open a connection to host:port
+				"Type": "tcp",						// "http" by default
+				"Body": "PING\r\n",					// optional payload sent on the connection
+				"Expect": "^\\+PONG"				// optional pattern that the response must match (otherwise, the response is read until the connection is closed if it has assertions)
+			},
+			{
+				"URL": "dns://1.1.1.1/example.com?type=MX",	// DNS checks query a server (port 53 by default) for a record
+				"Type": "dns",						// record types: A (default), AAAA, CNAME, MX, TXT
+				"Expect": "(?m)^10 mx\\.example\\.com\\.$",	// optional pattern that the answers (one per line) must match
+				"Policy": { "MaxResponseTime": 200 }
+			},
+			{
+				"URL": "grpcs://orders.internal:443/orders.v1.Orders",	// gRPC checks call grpc.health.v1.Health/Check
+				"Type": "grpc",						// grpc:// for plaintext HTTP/2, grpcs:// for TLS; the service name is optional
+				"Headers": { "x-api-key": "secret" },	// optional metadata
+				"TLS": { "CAFile": "/etc/monitord/internal-ca.pem" }
+			},
+			{
+				"URL": "wss://chat.example.com/socket",	// WebSocket checks perform the upgrade handshake
+				"Type": "websocket",
+				"Body": "{\"type\": \"ping\"}",		// optional message sent once connected
+				"Expect": "\"pong\"",				// optional pattern that the reply must match
+				"ReplyTimeout": 2000				// in ms, 4000 by default
+			},
+			{
+				"URL": "https://shop.example.com",	// journey checks run their steps in order, relative to URL
+				"Type": "journey",
+				"Steps": [
+					{
+						"Name": "login",
+						"URL": "/api/login",
+						"Method": "POST",
+						"Body": "{\"user\": \"monitor\"}",
+						"Extract": {					// variables reused as {{name}} in later steps
+							"token": { "JSONPath": "$.token" },	// or "Header", or "Cookie"
+							"id": { "JSONPath": "$.user.id" }
+						}
+					},
+					{
+						"Name": "account",			// cookies set by previous steps are sent automatically
+						"URL": "/api/accounts/{{id}}",
+						"Headers": { "Authorization": "Bearer {{token}}" },
+						"Assertions": [ { "JSONPath": "$.status", "Equals": "active" } ]
+					}
+				]
 			},
 			{ "URL": "https://golang.org" }
   		]
 	}
+
+Notifications
+
+When a website crosses its threshold, or when its TLS certificate is about
+to expire, the following JSON document is posted to each of its webhook
+endpoints, and passed on the standard input of the Exec command:
+
+	{
+		"type": "availability",					// "availability" or "certificate"
+		"url": "https://www.datadoghq.com",
+		"status": "down",						// "down" or "up" ("expiring" or "renewed" for certificate alerts)
+		"availability": 0.75,
+		"threshold": 0.95,
+		"date": "2018-03-01T12:00:00Z",			// the date at which the alert was generated
+		"timeframe": {							// the time window over which availability was computed
+			"start": "2018-03-01T11:58:00Z",
+			"end": "2018-03-01T12:00:00Z",
+			"seconds": 120
+		},
+		"certificate": {						// only for certificate alerts
+			"subject": "www.datadoghq.com",
+			"issuer": "R3",
+			"expires": "2018-03-10T00:00:00Z",	// the earliest expiry date of the certificate chain
+			"days_until_expiry": 8
+		}
+	}
+
+The Exec command also receives the alert as environment variables:
+MONITOR_TYPE, MONITOR_URL, MONITOR_STATUS, MONITOR_AVAILABILITY, MONITOR_THRESHOLD,
+MONITOR_DATE, MONITOR_TIMEFRAME_START, MONITOR_TIMEFRAME_END
+and MONITOR_TIMEFRAME_SECONDS, as well as MONITOR_CERTIFICATE_EXPIRES
+and MONITOR_CERTIFICATE_DAYS for certificate alerts.
 */
 package main
 
@@ -58,8 +232,12 @@ func main() {
 	websites := daemon.NewWebsites(&config)
 	websites.InitPolls()
 
+	// Start checking websites' availability to generate alerts
+	engine := daemon.NewAlertEngine(websites, &config)
+	engine.Start()
+
 	// Create RPC handler and start serving requests
-	h := daemon.Handler(websites)
+	h := daemon.Handler{Websites: websites, History: engine.History}
 	daemon.ServeRPC(&h, config.ListeningPort, interrupt)
 
 	return
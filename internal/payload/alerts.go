@@ -1,14 +1,63 @@
 package payload
 
-// Alerts maps from a website URL to an Alert.
-type Alerts map[string]Alert
+import "time"
+
+// Alerts is a list of alerts, sorted by increasing date.
+type Alerts []Alert
+
+// Types of alerts.
+const (
+	AvailabilityAlert = "availability" // The availability of the website crossed its threshold
+	CertificateAlert  = "certificate"  // The TLS certificate of the website is about to expire, or was renewed
+)
 
 // Alert represents an alert for a particular website.
 type Alert struct {
+	Type         string    // AvailabilityAlert or CertificateAlert
+	URL          string    // URL of the website concerned by the alert
+	Date         time.Time // Date at which the alert was recorded by the daemon
 	Timeframe    Timeframe // Time window use to aggregate results
-	Availability float64   // Average availability of the website
+	Availability float64   // Average availability of the website (availability alerts only)
+
+	// Threshold is the availability threshold of the website for availability alerts,
+	// and the number of days before expiry under which an alert is raised for certificate alerts.
+	Threshold float64
 
 	// BelowThreshold indicates whether the website is considered
-	// down (new alert) or up (recovery alert)
+	// down (new alert) or up (recovery alert). For certificate alerts,
+	// it indicates whether the certificate expires soon or was renewed.
 	BelowThreshold bool
+
+	// Certificate is the latest TLS certificate of the website (certificate alerts only)
+	Certificate *Certificate
+}
+
+// HistoryQuery is used to query the alert history of the daemon.
+type HistoryQuery struct {
+	Timeframe Timeframe // Only incidents overlapping this time window are returned
+	URL       string    // If not empty, only incidents of this website are returned
+}
+
+// Incidents is a list of incidents, sorted by increasing start date.
+type Incidents []Incident
+
+// An Incident represents a period during which a website was considered down,
+// i.e. the period between a "website is down" alert and the matching recovery alert.
+type Incident struct {
+	URL       string
+	StartDate time.Time // Date of the "website is down" alert
+	EndDate   time.Time // Date of the recovery alert, or zero if the website is still down
+
+	// Duration is the duration of the incident.
+	// If the website is still down, it is the time elapsed since StartDate.
+	Duration time.Duration
+
+	// Availability is the average availability of the website
+	// when the "website is down" alert was generated.
+	Availability float64
+}
+
+// Ongoing returns whether the website is still down.
+func (i Incident) Ongoing() bool {
+	return i.EndDate.IsZero()
 }
@@ -13,11 +13,74 @@ type Stats struct {
 
 // A Metric contains the aggregated poll results of one website.
 type Metric struct {
-	Availability     float64        // Average availability
-	Average          Timing         // Average HTTP lifecycle times
-	Max              Timing         // Max HTTP lifecycle times
+	Availability float64 // Average availability
+	Recovered    int     // Number of valid poll results that only succeeded after being retried
+
+	// Timings are aggregated separately for valid and invalid poll results
+	// (e.g. errors and 5xx responses), so that fast errors do not make
+	// a broken website look fast.
+	All     TimingStats // Timings of all poll results
+	Valid   TimingStats // Timings of valid poll results
+	Invalid TimingStats // Timings of invalid poll results
+
 	StatusCodeCounts map[int]int    // Maps from an HTTP response code to the number of times it was encountered
 	ErrorCounts      map[string]int // Maps from a client error string to the number of times it was encountered
+	PolicyViolations map[string]int // Maps from a policy violation to the number of times it was encountered
+	Histogram        []Bucket       // Distribution of response times, sorted by increasing upper bound
+	Certificate      *Certificate   // TLS certificate of the latest HTTPS poll result, or nil if there is none
+	Warnings         []string       // Configuration issues that website maintainers should be aware of
+
+	// Hops is the redirect chain of the latest poll result, from the requested URL
+	// to the final response, with the times of each hop averaged over the poll results
+	// that went through it. It is empty if the latest poll result was not redirected.
+	Hops []Hop
+}
+
+// A Hop is a request of a redirect chain.
+type Hop struct {
+	URL        string // URL of the request
+	StatusCode int    // Response code of the request, or 0 if it resulted in a client error
+	Timing     Timing // HTTP lifecycle times of the request
+}
+
+// Certificate describes the TLS certificate chain presented by a website.
+type Certificate struct {
+	Subject       string    // Common name of the leaf certificate
+	Issuer        string    // Common name of the issuer of the leaf certificate
+	SANs          []string  // DNS names of the leaf certificate
+	NotAfter      time.Time // Expiry date of the leaf certificate
+	ChainNotAfter time.Time // Earliest expiry date of the certificates of the chain
+	HostnameMatch bool      // Whether the leaf certificate is valid for the host name of the website
+
+	// DaysUntilExpiry is the number of full days until ChainNotAfter,
+	// computed when the metric or alert is generated. It is negative
+	// if the certificate has expired.
+	DaysUntilExpiry int
+}
+
+// TimingStats contains the aggregated HTTP lifecycle times of a set of poll results.
+//
+// Percentiles are only computed from raw poll results: they are left empty
+// if part of the timeframe is covered by rollups.
+type TimingStats struct {
+	Count   int    // Number of poll results
+	Average Timing // Average HTTP lifecycle times
+	Max     Timing // Max HTTP lifecycle times
+	P50     Timing // Median HTTP lifecycle times
+	P90     Timing // 90th percentile of HTTP lifecycle times
+	P95     Timing // 95th percentile of HTTP lifecycle times
+	P99     Timing // 99th percentile of HTTP lifecycle times
+}
+
+// A Bucket counts the poll results whose response time falls in a given range.
+type Bucket struct {
+	// Max is the upper bound (excluded) of the range.
+	// The lower bound is the Max of the previous bucket, or 0 for the first bucket.
+	// The last bucket has no upper bound, and its Max is set to 0.
+	Max time.Duration
+
+	// Count is the number of poll results in the range.
+	Count int
 }
 
 // A Timing contains the durations of each phase of an HTTP request.